@@ -3,18 +3,63 @@ package main
 import (
 	"AutoCert/src/application/request"
 	"AutoCert/src/utils"
+	"flag"
+	"fmt"
 	"log"
+	"os"
 )
 
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage:\n")
+	fmt.Fprintf(os.Stderr, "  %s                 check all domains and renew expiring certificates\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s dns prune [-n]  delete orphaned DNS validation TXT records\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s rollback <domain> [--to version]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "      redeploy the previous (or the given) stored certificate of a domain\n")
+}
+
 func main() {
-	config := utils.InitializationConfig()
-	utils.CheckSSLCertificates(config)
+	args := os.Args[1:]
+	if len(args) == 0 {
+		config := utils.InitializationConfig()
+
+		log.Println("[INFO] Starting SSL certificate processing")
+		request.ProcessCertificates(config)
+		log.Println("[INFO] SSL certificate processing completed")
+		return
+	}
+
+	switch {
+	case len(args) >= 2 && args[0] == "dns" && args[1] == "prune":
+		flags := flag.NewFlagSet("dns prune", flag.ExitOnError)
+		dryRun := flags.Bool("n", false, "only list the records that would be deleted")
+		flags.Parse(args[2:])
 
-	// fmt.Println("开始处理阿里云SSL证书...")
-	// request.ProcessAliyunSSLCertificates(config)
-	// fmt.Println("阿里云SSL证书处理完成")
+		config := utils.InitializationConfig()
+		if err := request.PruneDNSRecords(config, *dryRun); err != nil {
+			log.Fatalf("[ERROR] %v", err)
+		}
+	case len(args) >= 1 && args[0] == "rollback":
+		flags := flag.NewFlagSet("rollback", flag.ExitOnError)
+		version := flags.String("to", "", "stored version to roll back to, defaults to the one before the current version")
+		flags.Parse(args[1:])
+		if flags.NArg() < 1 {
+			usage()
+			os.Exit(2)
+		}
+		// Accept the flag after the domain as well
+		domain := flags.Arg(0)
+		flags.Parse(flags.Args()[1:])
+		if flags.NArg() > 0 {
+			usage()
+			os.Exit(2)
+		}
 
-	log.Println("[INFO] Starting TencentCloud SSL certificate processing")
-	request.ProcessTencentCloudCertificates(config)
-	log.Println("[INFO] TencentCloud SSL certificate processing completed")
+		config := utils.InitializationConfig()
+		if err := request.RollbackCertificate(config, domain, *version); err != nil {
+			log.Fatalf("[ERROR] %v", err)
+		}
+	default:
+		usage()
+		os.Exit(2)
+	}
 }
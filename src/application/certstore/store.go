@@ -0,0 +1,312 @@
+package certstore
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultRoot is used when [store] root is not configured
+	DefaultRoot = "gitignore/certs"
+	// DefaultRetention is the number of versions kept per domain
+	DefaultRetention = 5
+
+	currentLink   = "current"
+	versionLayout = "20060102T150405Z"
+
+	CertFile      = "cert.pem"
+	ChainFile     = "chain.pem"
+	FullChainFile = "fullchain.pem"
+	KeyFile       = "key.pem"
+	MetaFile      = "meta.json"
+)
+
+// Meta describes a stored certificate version
+type Meta struct {
+	Domain      string    `json:"domain"`
+	Version     string    `json:"version"`
+	Platform    string    `json:"platform,omitempty"`
+	OrderID     string    `json:"order_id,omitempty"`
+	Serial      string    `json:"serial"`
+	Issuer      string    `json:"issuer"`
+	DNSNames    []string  `json:"dns_names"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	Fingerprint string    `json:"fingerprint_sha256"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Version is one certificate of a domain laid out as <root>/<domain>/<version>/
+type Version struct {
+	Dir  string
+	Meta Meta
+}
+
+func (v *Version) CertPath() string      { return filepath.Join(v.Dir, CertFile) }
+func (v *Version) ChainPath() string     { return filepath.Join(v.Dir, ChainFile) }
+func (v *Version) FullChainPath() string { return filepath.Join(v.Dir, FullChainFile) }
+func (v *Version) KeyPath() string       { return filepath.Join(v.Dir, KeyFile) }
+
+// Material is the PEM content of a version
+type Material struct {
+	CertificatePEM []byte
+	ChainPEM       []byte
+	FullChainPEM   []byte
+	PrivateKeyPEM  []byte
+}
+
+// Load reads the PEM files of the version
+func (v *Version) Load() (*Material, error) {
+	var material Material
+	files := []struct {
+		path string
+		dest *[]byte
+	}{
+		{v.CertPath(), &material.CertificatePEM},
+		{v.ChainPath(), &material.ChainPEM},
+		{v.FullChainPath(), &material.FullChainPEM},
+		{v.KeyPath(), &material.PrivateKeyPEM},
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file.path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", file.path, err)
+		}
+		*file.dest = data
+	}
+	return &material, nil
+}
+
+// Store keeps a versioned history of certificates per domain with a
+// "current" symlink pointing at the version that should be deployed
+type Store struct {
+	root      string
+	retention int
+}
+
+// New returns a store rooted at root that keeps retention versions per domain
+func New(root string, retention int) *Store {
+	if root == "" {
+		root = DefaultRoot
+	}
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	return &Store{root: root, retention: retention}
+}
+
+// domainDir returns the directory of a domain, "*" is not portable in paths
+func (s *Store) domainDir(domain string) string {
+	return filepath.Join(s.root, strings.ReplaceAll(domain, "*", "_"))
+}
+
+// Save writes a new version, points "current" at it and applies the retention policy
+func (s *Store) Save(domain string, certPEM, chainPEM, keyPEM []byte, meta Meta) (*Version, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM certificate for domain %s", domain)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate for domain %s: %v", domain, err)
+	}
+
+	now := time.Now().UTC()
+	fingerprint := sha256.Sum256(cert.Raw)
+	meta.Domain = domain
+	meta.Serial = cert.SerialNumber.Text(16)
+	meta.Issuer = cert.Issuer.String()
+	meta.DNSNames = cert.DNSNames
+	meta.NotBefore = cert.NotBefore
+	meta.NotAfter = cert.NotAfter
+	meta.Fingerprint = hex.EncodeToString(fingerprint[:])
+	meta.CreatedAt = now
+
+	domainDir := s.domainDir(domain)
+	if err := os.MkdirAll(domainDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create certificate directory: %v", err)
+	}
+
+	// Write into a temporary directory first so a version is either complete or absent
+	tmpDir, err := os.MkdirTemp(domainDir, ".tmp-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	fullChain := append(append([]byte{}, certPEM...), chainPEM...)
+	files := []struct {
+		name string
+		data []byte
+		mode os.FileMode
+	}{
+		{CertFile, certPEM, 0644},
+		{ChainFile, chainPEM, 0644},
+		{FullChainFile, fullChain, 0644},
+		{KeyFile, keyPEM, 0600},
+	}
+	for _, file := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, file.name), file.data, file.mode); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %v", file.name, err)
+		}
+	}
+
+	version, versionDir := s.nextVersion(domainDir, now)
+	meta.Version = version
+	metaJSON, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode certificate metadata: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, MetaFile), metaJSON, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %v", MetaFile, err)
+	}
+
+	if err := os.Rename(tmpDir, versionDir); err != nil {
+		return nil, fmt.Errorf("failed to store certificate version: %v", err)
+	}
+	log.Printf("[INFO] Stored certificate for domain %s as version %s", domain, version)
+
+	if err := s.SetCurrent(domain, version); err != nil {
+		return nil, err
+	}
+	if err := s.Prune(domain); err != nil {
+		log.Printf("[WARN] Failed to apply retention policy for domain %s: %v", domain, err)
+	}
+
+	return &Version{Dir: versionDir, Meta: meta}, nil
+}
+
+// nextVersion returns a timestamp version name that does not exist yet
+func (s *Store) nextVersion(domainDir string, now time.Time) (string, string) {
+	version := now.Format(versionLayout)
+	for i := 1; ; i++ {
+		dir := filepath.Join(domainDir, version)
+		if _, err := os.Lstat(dir); os.IsNotExist(err) {
+			return version, dir
+		}
+		version = fmt.Sprintf("%s-%d", now.Format(versionLayout), i)
+	}
+}
+
+// validVersion rejects version names that would leave the domain directory or
+// name the "current" symlink and temporary entries, e.g. from rollback --to
+func validVersion(version string) error {
+	if version == "" || version == currentLink || strings.HasPrefix(version, ".") || strings.ContainsAny(version, `/\`) {
+		return fmt.Errorf("invalid certificate version %q", version)
+	}
+	return nil
+}
+
+// SetCurrent atomically points the "current" symlink of the domain at version
+func (s *Store) SetCurrent(domain, version string) error {
+	if err := validVersion(version); err != nil {
+		return err
+	}
+	domainDir := s.domainDir(domain)
+	if _, err := os.Stat(filepath.Join(domainDir, version, MetaFile)); err != nil {
+		return fmt.Errorf("version %s of domain %s not found: %v", version, domain, err)
+	}
+
+	tmpLink := filepath.Join(domainDir, fmt.Sprintf(".%s-%d", currentLink, time.Now().UnixNano()))
+	if err := os.Symlink(version, tmpLink); err != nil {
+		return fmt.Errorf("failed to create current symlink: %v", err)
+	}
+	if err := os.Rename(tmpLink, filepath.Join(domainDir, currentLink)); err != nil {
+		os.Remove(tmpLink)
+		return fmt.Errorf("failed to update current symlink: %v", err)
+	}
+	return nil
+}
+
+// Current returns the version the "current" symlink points at
+func (s *Store) Current(domain string) (*Version, error) {
+	target, err := os.Readlink(filepath.Join(s.domainDir(domain), currentLink))
+	if err != nil {
+		return nil, fmt.Errorf("no current certificate for domain %s: %v", domain, err)
+	}
+	return s.Get(domain, filepath.Base(target))
+}
+
+// Get returns a specific version of the domain
+func (s *Store) Get(domain, version string) (*Version, error) {
+	if err := validVersion(version); err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(s.domainDir(domain), version)
+	data, err := os.ReadFile(filepath.Join(dir, MetaFile))
+	if err != nil {
+		return nil, fmt.Errorf("version %s of domain %s not found: %v", version, domain, err)
+	}
+
+	var meta Meta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("invalid metadata of version %s of domain %s: %v", version, domain, err)
+	}
+	return &Version{Dir: dir, Meta: meta}, nil
+}
+
+// Versions returns all versions of the domain, newest first
+func (s *Store) Versions(domain string) ([]*Version, error) {
+	entries, err := os.ReadDir(s.domainDir(domain))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions of domain %s: %v", domain, err)
+	}
+
+	var versions []*Version
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		version, err := s.Get(domain, entry.Name())
+		if err != nil {
+			log.Printf("[WARN] Skipping broken certificate version: %v", err)
+			continue
+		}
+		versions = append(versions, version)
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		if !versions[i].Meta.CreatedAt.Equal(versions[j].Meta.CreatedAt) {
+			return versions[i].Meta.CreatedAt.After(versions[j].Meta.CreatedAt)
+		}
+		return versions[i].Meta.Version > versions[j].Meta.Version
+	})
+	return versions, nil
+}
+
+// Prune removes the oldest versions beyond the retention count, never the current one
+func (s *Store) Prune(domain string) error {
+	versions, err := s.Versions(domain)
+	if err != nil {
+		return err
+	}
+
+	current := ""
+	if version, err := s.Current(domain); err == nil {
+		current = version.Meta.Version
+	}
+
+	for idx, version := range versions {
+		if idx < s.retention || version.Meta.Version == current {
+			continue
+		}
+		if err := os.RemoveAll(version.Dir); err != nil {
+			return fmt.Errorf("failed to remove version %s: %v", version.Meta.Version, err)
+		}
+		log.Printf("[INFO] Removed expired certificate version %s of domain %s", version.Meta.Version, domain)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package certstore
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// saveTestCert stores a fresh self-signed certificate for domain
+func saveTestCert(t *testing.T, store *Store, domain string) *Version {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	version, err := store.Save(domain, certPEM, nil, keyPEM, Meta{Platform: "acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+func versionNames(t *testing.T, store *Store, domain string) []string {
+	t.Helper()
+	versions, err := store.Versions(domain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, version := range versions {
+		names = append(names, version.Meta.Version)
+	}
+	return names
+}
+
+func TestStoreSaveAndGet(t *testing.T) {
+	root := t.TempDir()
+	store := New(root, 5)
+
+	first := saveTestCert(t, store, "*.example.com")
+	second := saveTestCert(t, store, "*.example.com")
+	if first.Meta.Version == second.Meta.Version {
+		t.Fatalf("two saves share version %s", first.Meta.Version)
+	}
+	if filepath.Dir(first.Dir) != filepath.Join(root, "_.example.com") {
+		t.Errorf("version stored in %s", first.Dir)
+	}
+
+	got, err := store.Get("*.example.com", first.Meta.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Meta.Fingerprint != first.Meta.Fingerprint || got.Meta.Platform != "acme" || got.Meta.Domain != "*.example.com" {
+		t.Errorf("Get returned %+v", got.Meta)
+	}
+	material, err := got.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(material.FullChainPEM) != string(material.CertificatePEM) {
+		t.Error("fullchain of a certificate without chain differs from the certificate")
+	}
+
+	info, err := os.Stat(got.KeyPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("key has mode %v, want 0600", info.Mode().Perm())
+	}
+
+	names := versionNames(t, store, "*.example.com")
+	if len(names) != 2 || names[0] != second.Meta.Version || names[1] != first.Meta.Version {
+		t.Errorf("Versions returned %v, want newest first", names)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(root, "_.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != currentLink && entry.Name() != first.Meta.Version && entry.Name() != second.Meta.Version {
+			t.Errorf("leftover entry %s in the domain directory", entry.Name())
+		}
+	}
+}
+
+func TestStoreSetCurrent(t *testing.T) {
+	store := New(t.TempDir(), 5)
+	first := saveTestCert(t, store, "www.example.com")
+	second := saveTestCert(t, store, "www.example.com")
+
+	current, err := store.Current("www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Meta.Version != second.Meta.Version {
+		t.Errorf("current is %s after saving %s", current.Meta.Version, second.Meta.Version)
+	}
+
+	if err := store.SetCurrent("www.example.com", first.Meta.Version); err != nil {
+		t.Fatal(err)
+	}
+	current, err = store.Current("www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Meta.Version != first.Meta.Version {
+		t.Errorf("current is %s after switching to %s", current.Meta.Version, first.Meta.Version)
+	}
+
+	if err := store.SetCurrent("www.example.com", "20000101T000000Z"); err == nil {
+		t.Error("SetCurrent accepted a missing version")
+	}
+	if current, err := store.Current("www.example.com"); err != nil || current.Meta.Version != first.Meta.Version {
+		t.Errorf("failed switch changed current: %v", err)
+	}
+
+	if _, err := store.Current("other.example.com"); err == nil {
+		t.Error("Current succeeded for a domain without certificates")
+	}
+}
+
+func TestStorePruneKeepsCurrent(t *testing.T) {
+	root := t.TempDir()
+	store := New(root, 3)
+	var saved []*Version
+	for i := 0; i < 4; i++ {
+		saved = append(saved, saveTestCert(t, store, "www.example.com"))
+	}
+
+	// Saving applies the retention policy
+	names := versionNames(t, store, "www.example.com")
+	if len(names) != 3 || names[2] != saved[1].Meta.Version {
+		t.Fatalf("kept %v, want the 3 newest", names)
+	}
+
+	// A rolled back current version survives a stricter retention
+	if err := store.SetCurrent("www.example.com", saved[1].Meta.Version); err != nil {
+		t.Fatal(err)
+	}
+	if err := New(root, 1).Prune("www.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	names = versionNames(t, store, "www.example.com")
+	if len(names) != 2 || names[0] != saved[3].Meta.Version || names[1] != saved[1].Meta.Version {
+		t.Errorf("kept %v, want %s and current %s", names, saved[3].Meta.Version, saved[1].Meta.Version)
+	}
+}
+
+func TestStoreRejectsInvalidVersions(t *testing.T) {
+	root := t.TempDir()
+	store := New(root, 5)
+	saveTestCert(t, store, "www.example.com")
+	saveTestCert(t, store, "other.example.com")
+
+	for _, version := range []string{"", ".", "..", "../other.example.com/current", "a/b", `a\b`, "/tmp", currentLink, ".tmp-1"} {
+		if _, err := store.Get("www.example.com", version); err == nil {
+			t.Errorf("Get accepted version %q", version)
+		}
+		if err := store.SetCurrent("www.example.com", version); err == nil {
+			t.Errorf("SetCurrent accepted version %q", version)
+		}
+	}
+}
@@ -1 +1,446 @@
 package deploy
+
+import (
+	"AutoCert/src/application/certstore"
+	"AutoCert/src/utils"
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("akilight", newAkiLightDeployer)
+}
+
+// akiLightDeployer uploads certificates to an AkiLight (GoEdge compatible) admin
+// API and switches the SSL policies of the domain's servers over to them
+type akiLightDeployer struct {
+	config utils.Config
+	client *http.Client
+}
+
+func newAkiLightDeployer(config utils.Config) (Deployer, error) {
+	if config.AkiLight.Endpoint == "" {
+		return nil, fmt.Errorf("akilight endpoint is not configured")
+	}
+	return &akiLightDeployer{
+		config: config,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+type akiLightResponse struct {
+	Code    int             `json:"code"`
+	Data    json.RawMessage `json:"data"`
+	Message string          `json:"message"`
+}
+
+// call posts to {endpoint}/{Service}/{method} and decodes the data field into result
+func (d *akiLightDeployer) call(path string, params interface{}, result interface{}) error {
+	token, err := utils.GetCachedToken(&d.config)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s request: %v", path, err)
+	}
+
+	request, err := http.NewRequest(http.MethodPost, strings.TrimRight(d.config.AkiLight.Endpoint, "/")+"/"+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create %s request: %v", path, err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Edge-Access-Token", token)
+
+	resp, err := d.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read %s response: %v", path, err)
+	}
+
+	var apiResp akiLightResponse
+	if err := json.Unmarshal(respBody, &apiResp); err != nil {
+		return fmt.Errorf("failed to parse %s response: %v", path, err)
+	}
+	if apiResp.Code != 200 {
+		return fmt.Errorf("%s failed: %s", path, apiResp.Message)
+	}
+
+	if result != nil && len(apiResp.Data) > 0 {
+		if err := json.Unmarshal(apiResp.Data, result); err != nil {
+			return fmt.Errorf("failed to parse %s data: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// akiLightCert is the subset of SSLCertConfig needed to find certificates
+type akiLightCert struct {
+	Id       int64    `json:"id"`
+	Name     string   `json:"name"`
+	DNSNames []string `json:"dnsNames"`
+}
+
+// akiLightCertRef is a reference from an SSL policy to a certificate
+type akiLightCertRef struct {
+	IsOn   bool  `json:"isOn"`
+	CertId int64 `json:"certId"`
+}
+
+// akiLightPolicy is the subset of SSLPolicy needed to rewrite its certificates
+type akiLightPolicy struct {
+	Id               int64             `json:"id"`
+	CertRefs         []akiLightCertRef `json:"certRefs"`
+	HTTP2Enabled     bool              `json:"http2Enabled"`
+	HTTP3Enabled     bool              `json:"http3Enabled"`
+	MinVersion       string            `json:"minVersion"`
+	CipherSuitesIsOn bool              `json:"cipherSuitesIsOn"`
+	CipherSuites     []string          `json:"cipherSuites"`
+	HSTS             json.RawMessage   `json:"hsts"`
+	ClientAuthType   int32             `json:"clientAuthType"`
+	OCSPIsOn         bool              `json:"ocspIsOn"`
+}
+
+// akiLightCertName is the name of a stored version in AkiLight, unique per version so older ones stay available
+func akiLightCertName(domain string, version *certstore.Version) string {
+	return fmt.Sprintf("autocert:%s:%s", domain, version.Meta.Version)
+}
+
+// listCerts returns the certificates matching the keyword
+func (d *akiLightDeployer) listCerts(keyword string) ([]akiLightCert, error) {
+	var result struct {
+		SSLCertsJSON []byte `json:"sslCertsJSON"`
+	}
+	err := d.call("SSLCertService/listSSLCerts", map[string]interface{}{
+		"keyword": keyword,
+		"offset":  0,
+		"size":    1000,
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []akiLightCert
+	if len(result.SSLCertsJSON) > 0 {
+		if err := json.Unmarshal(result.SSLCertsJSON, &certs); err != nil {
+			return nil, fmt.Errorf("failed to parse certificate list: %v", err)
+		}
+	}
+	return certs, nil
+}
+
+// uploadCert creates the certificate of the version, or updates it when a certificate with the same name exists
+func (d *akiLightDeployer) uploadCert(domain string, version *certstore.Version) (int64, error) {
+	material, err := version.Load()
+	if err != nil {
+		return 0, err
+	}
+
+	block, _ := pem.Decode(material.CertificatePEM)
+	if block == nil {
+		return 0, fmt.Errorf("invalid certificate of version %s", version.Meta.Version)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+
+	name := akiLightCertName(domain, version)
+	params := map[string]interface{}{
+		"isOn":        true,
+		"name":        name,
+		"description": "Managed by AutoCert",
+		"serverName":  domain,
+		"isCA":        false,
+		"certData":    material.FullChainPEM,
+		"keyData":     material.PrivateKeyPEM,
+		"timeBeginAt": cert.NotBefore.Unix(),
+		"timeEndAt":   cert.NotAfter.Unix(),
+		"dnsNames":    cert.DNSNames,
+		"commonNames": []string{cert.Issuer.CommonName},
+	}
+
+	existing, err := d.listCerts(domain)
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range existing {
+		if c.Name == name {
+			params["sslCertId"] = c.Id
+			log.Printf("[INFO] Updating AkiLight certificate %d (%s)", c.Id, name)
+			if err := d.call("SSLCertService/updateSSLCert", params, nil); err != nil {
+				return 0, err
+			}
+			return c.Id, nil
+		}
+	}
+
+	var result struct {
+		SSLCertId int64 `json:"sslCertId"`
+	}
+	log.Printf("[INFO] Creating AkiLight certificate %s", name)
+	if err := d.call("SSLCertService/createSSLCert", params, &result); err != nil {
+		return 0, err
+	}
+	if result.SSLCertId == 0 {
+		return 0, fmt.Errorf("createSSLCert returned no certificate ID")
+	}
+	return result.SSLCertId, nil
+}
+
+// serverPolicyIds returns the SSL policies used by servers matching the domain
+func (d *akiLightDeployer) serverPolicyIds(domain string) ([]int64, error) {
+	var result struct {
+		Servers []struct {
+			Id        int64  `json:"id"`
+			Name      string `json:"name"`
+			HTTPSJSON []byte `json:"httpsJSON"`
+		} `json:"servers"`
+	}
+	err := d.call("ServerService/listEnabledServersMatch", map[string]interface{}{
+		"keyword": domain,
+		"offset":  0,
+		"size":    1000,
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[int64]bool{}
+	var policyIds []int64
+	for _, server := range result.Servers {
+		if len(server.HTTPSJSON) == 0 {
+			continue
+		}
+		var https struct {
+			IsOn         bool `json:"isOn"`
+			SSLPolicyRef *struct {
+				SSLPolicyId int64 `json:"sslPolicyId"`
+			} `json:"sslPolicyRef"`
+		}
+		if err := json.Unmarshal(server.HTTPSJSON, &https); err != nil {
+			log.Printf("[WARN] Failed to parse HTTPS config of AkiLight server %d: %v", server.Id, err)
+			continue
+		}
+		if https.SSLPolicyRef == nil || https.SSLPolicyRef.SSLPolicyId == 0 || seen[https.SSLPolicyRef.SSLPolicyId] {
+			continue
+		}
+		seen[https.SSLPolicyRef.SSLPolicyId] = true
+		policyIds = append(policyIds, https.SSLPolicyRef.SSLPolicyId)
+	}
+	return policyIds, nil
+}
+
+func (d *akiLightDeployer) findPolicy(policyId int64) (*akiLightPolicy, error) {
+	var result struct {
+		SSLPolicyJSON []byte `json:"sslPolicyJSON"`
+	}
+	if err := d.call("SSLPolicyService/findEnabledSSLPolicyConfig", map[string]interface{}{"sslPolicyId": policyId}, &result); err != nil {
+		return nil, err
+	}
+
+	var policy akiLightPolicy
+	if err := json.Unmarshal(result.SSLPolicyJSON, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse SSL policy %d: %v", policyId, err)
+	}
+	return &policy, nil
+}
+
+func (d *akiLightDeployer) updatePolicy(policy *akiLightPolicy) error {
+	certRefsJSON, err := json.Marshal(policy.CertRefs)
+	if err != nil {
+		return fmt.Errorf("failed to encode certificate references: %v", err)
+	}
+
+	var hstsJSON []byte
+	if len(policy.HSTS) > 0 && string(policy.HSTS) != "null" {
+		hstsJSON = policy.HSTS
+	}
+
+	return d.call("SSLPolicyService/updateSSLPolicy", map[string]interface{}{
+		"sslPolicyId":      policy.Id,
+		"http2Enabled":     policy.HTTP2Enabled,
+		"http3Enabled":     policy.HTTP3Enabled,
+		"minVersion":       policy.MinVersion,
+		"sslCertsJSON":     certRefsJSON,
+		"hstsJSON":         hstsJSON,
+		"clientAuthType":   policy.ClientAuthType,
+		"cipherSuitesIsOn": policy.CipherSuitesIsOn,
+		"cipherSuites":     policy.CipherSuites,
+		"ocspIsOn":         policy.OCSPIsOn,
+	}, nil)
+}
+
+// domainCerts splits the certificates other than certId that cover the domain
+// into all of them and those replaced by certId. A certificate with names
+// certId lacks, such as a wildcard shared with other hosts, is kept.
+func domainCerts(certs []akiLightCert, domain string, certId int64) (others, replaced map[int64]bool) {
+	var newNames []string
+	for _, c := range certs {
+		if c.Id == certId {
+			newNames = c.DNSNames
+		}
+	}
+
+	others = map[int64]bool{}
+	replaced = map[int64]bool{}
+	for _, c := range certs {
+		if c.Id == certId || !coversDomain(c.DNSNames, domain) {
+			continue
+		}
+		others[c.Id] = true
+		if coversAll(newNames, c.DNSNames) {
+			replaced[c.Id] = true
+		}
+	}
+	return others, replaced
+}
+
+// servesDomain reports whether the policy references certId or one of others
+func servesDomain(policy *akiLightPolicy, certId int64, others map[int64]bool) bool {
+	for _, ref := range policy.CertRefs {
+		if ref.CertId == certId || others[ref.CertId] {
+			return true
+		}
+	}
+	return false
+}
+
+// switchTo adds certId to every SSL policy of the domain's servers that serves
+// a certificate of the domain and removes the certificates it replaces
+func (d *akiLightDeployer) switchTo(domain string, certId int64) error {
+	certs, err := d.listCerts(domain)
+	if err != nil {
+		return err
+	}
+	others, replaced := domainCerts(certs, domain, certId)
+
+	policyIds, err := d.serverPolicyIds(domain)
+	if err != nil {
+		return err
+	}
+
+	var updated []int64
+	for _, policyId := range policyIds {
+		policy, err := d.findPolicy(policyId)
+		if err != nil {
+			return err
+		}
+		if !servesDomain(policy, certId, others) {
+			continue
+		}
+
+		changed := false
+		var refs []akiLightCertRef
+		hasNew := false
+		for _, ref := range policy.CertRefs {
+			if replaced[ref.CertId] {
+				changed = true
+				continue
+			}
+			if ref.CertId == certId {
+				if !ref.IsOn {
+					ref.IsOn = true
+					changed = true
+				}
+				hasNew = true
+			}
+			refs = append(refs, ref)
+		}
+		if !hasNew {
+			refs = append(refs, akiLightCertRef{IsOn: true, CertId: certId})
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		policy.CertRefs = refs
+
+		log.Printf("[INFO] Switching AkiLight SSL policy %d of domain %s to certificate %d", policyId, domain, certId)
+		if err := d.updatePolicy(policy); err != nil {
+			return err
+		}
+		updated = append(updated, policyId)
+	}
+
+	if len(updated) == 0 {
+		log.Printf("[WARN] No AkiLight SSL policy of domain %s needed switching to certificate %d", domain, certId)
+	}
+	return nil
+}
+
+func (d *akiLightDeployer) Deploy(domain utils.Domain, version *certstore.Version) error {
+	certId, err := d.uploadCert(domain.DomainName, version)
+	if err != nil {
+		return err
+	}
+	return d.switchTo(domain.DomainName, certId)
+}
+
+// Verify checks that every SSL policy serving certificates of the domain
+// references the new one and none references a certificate it replaces
+func (d *akiLightDeployer) Verify(domain utils.Domain, version *certstore.Version) error {
+	name := akiLightCertName(domain.DomainName, version)
+	certs, err := d.listCerts(domain.DomainName)
+	if err != nil {
+		return err
+	}
+
+	var certId int64
+	for _, c := range certs {
+		if c.Name == name {
+			certId = c.Id
+		}
+	}
+	if certId == 0 {
+		return fmt.Errorf("certificate %s not found in AkiLight", name)
+	}
+	others, replaced := domainCerts(certs, domain.DomainName, certId)
+
+	policyIds, err := d.serverPolicyIds(domain.DomainName)
+	if err != nil {
+		return err
+	}
+
+	for _, policyId := range policyIds {
+		policy, err := d.findPolicy(policyId)
+		if err != nil {
+			return err
+		}
+		if !servesDomain(policy, certId, others) {
+			continue
+		}
+
+		hasNew := false
+		for _, ref := range policy.CertRefs {
+			if replaced[ref.CertId] {
+				return fmt.Errorf("SSL policy %d still references certificate %d", policyId, ref.CertId)
+			}
+			if ref.CertId == certId && ref.IsOn {
+				hasNew = true
+			}
+		}
+		if !hasNew {
+			return fmt.Errorf("SSL policy %d does not reference certificate %d", policyId, certId)
+		}
+	}
+	return nil
+}
+
+func (d *akiLightDeployer) Rollback(domain utils.Domain, previous *certstore.Version) error {
+	return d.Deploy(domain, previous)
+}
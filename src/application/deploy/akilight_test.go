@@ -0,0 +1,195 @@
+package deploy
+
+import (
+	"AutoCert/src/application/certstore"
+	"AutoCert/src/utils"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAkiLight serves the parts of the AkiLight admin API used by the deployer,
+// every server of the fake matches the keyword of the deployed domain
+type fakeAkiLight struct {
+	mu       sync.Mutex
+	certs    []akiLightCert
+	policies map[int64][]akiLightCertRef
+	nextId   int64
+}
+
+func (f *fakeAkiLight) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var params map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.URL.Path != "/APIAccessTokenService/getAPIAccessToken" && r.Header.Get("X-Edge-Access-Token") != "test-token" {
+		json.NewEncoder(w).Encode(map[string]interface{}{"code": 401, "message": "invalid token"})
+		return
+	}
+
+	var data interface{}
+	switch r.URL.Path {
+	case "/APIAccessTokenService/getAPIAccessToken":
+		data = map[string]interface{}{"token": "test-token", "expiresAt": time.Now().Add(time.Hour).Unix()}
+	case "/SSLCertService/listSSLCerts":
+		var keyword string
+		json.Unmarshal(params["keyword"], &keyword)
+		certs := []akiLightCert{}
+		for _, c := range f.certs {
+			if strings.Contains(c.Name, keyword) || coversDomain(c.DNSNames, keyword) {
+				certs = append(certs, c)
+			}
+		}
+		certsJSON, _ := json.Marshal(certs)
+		data = map[string]interface{}{"sslCertsJSON": certsJSON}
+	case "/SSLCertService/createSSLCert":
+		f.nextId++
+		cert := akiLightCert{Id: f.nextId}
+		json.Unmarshal(params["name"], &cert.Name)
+		json.Unmarshal(params["dnsNames"], &cert.DNSNames)
+		f.certs = append(f.certs, cert)
+		data = map[string]interface{}{"sslCertId": cert.Id}
+	case "/SSLCertService/updateSSLCert":
+	case "/ServerService/listEnabledServersMatch":
+		servers := []interface{}{}
+		for policyId := range f.policies {
+			httpsJSON, _ := json.Marshal(map[string]interface{}{
+				"isOn":         true,
+				"sslPolicyRef": map[string]interface{}{"sslPolicyId": policyId},
+			})
+			servers = append(servers, map[string]interface{}{"id": policyId, "httpsJSON": httpsJSON})
+		}
+		data = map[string]interface{}{"servers": servers}
+	case "/SSLPolicyService/findEnabledSSLPolicyConfig":
+		var policyId int64
+		json.Unmarshal(params["sslPolicyId"], &policyId)
+		policyJSON, _ := json.Marshal(akiLightPolicy{Id: policyId, CertRefs: f.policies[policyId], MinVersion: "TLS 1.1"})
+		data = map[string]interface{}{"sslPolicyJSON": policyJSON}
+	case "/SSLPolicyService/updateSSLPolicy":
+		var policyId int64
+		var refsJSON []byte
+		var refs []akiLightCertRef
+		json.Unmarshal(params["sslPolicyId"], &policyId)
+		json.Unmarshal(params["sslCertsJSON"], &refsJSON)
+		if err := json.Unmarshal(refsJSON, &refs); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.policies[policyId] = refs
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"code": 200, "data": data})
+}
+
+// certIds returns the certificates referenced by the policy
+func (f *fakeAkiLight) certIds(policyId int64) []int64 {
+	var ids []int64
+	for _, ref := range f.policies[policyId] {
+		ids = append(ids, ref.CertId)
+	}
+	return ids
+}
+
+func newTestAkiLight(t *testing.T, fake *fakeAkiLight) *akiLightDeployer {
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	var config utils.Config
+	config.AkiLight.Endpoint = server.URL
+	deployer, err := newAkiLightDeployer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return deployer.(*akiLightDeployer)
+}
+
+func TestAkiLightDeployKeepsSharedWildcard(t *testing.T) {
+	fake := &fakeAkiLight{
+		certs: []akiLightCert{
+			{Id: 1, Name: "autocert:www.example.com:old", DNSNames: []string{"www.example.com"}},
+			{Id: 2, Name: "wildcard", DNSNames: []string{"*.example.com"}},
+		},
+		policies: map[int64][]akiLightCertRef{
+			10: {{IsOn: true, CertId: 1}},
+			11: {{IsOn: true, CertId: 2}},
+			12: {{IsOn: true, CertId: 1}, {IsOn: true, CertId: 2}},
+		},
+		nextId: 100,
+	}
+	deployer := newTestAkiLight(t, fake)
+
+	certs := certstore.New(t.TempDir(), 5)
+	domain := utils.Domain{DomainName: "www.example.com"}
+	version := testVersion(t, certs, domain.DomainName, time.Now().Add(90*24*time.Hour), domain.DomainName)
+
+	if err := deployer.Deploy(domain, version); err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if err := deployer.Verify(domain, version); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	want := map[int64][]int64{10: {101}, 11: {2, 101}, 12: {2, 101}}
+	for policyId, ids := range want {
+		got := fake.certIds(policyId)
+		if len(got) != len(ids) {
+			t.Errorf("policy %d references %v, want %v", policyId, got, ids)
+			continue
+		}
+		for idx := range ids {
+			if got[idx] != ids[idx] {
+				t.Errorf("policy %d references %v, want %v", policyId, got, ids)
+				break
+			}
+		}
+	}
+}
+
+func TestAkiLightVerify(t *testing.T) {
+	certs := certstore.New(t.TempDir(), 5)
+	domain := utils.Domain{DomainName: "www.example.com"}
+	version := testVersion(t, certs, domain.DomainName, time.Now().Add(90*24*time.Hour), domain.DomainName)
+	name := akiLightCertName(domain.DomainName, version)
+
+	tests := []struct {
+		name     string
+		policies map[int64][]akiLightCertRef
+		wantErr  bool
+	}{
+		{"switched", map[int64][]akiLightCertRef{10: {{IsOn: true, CertId: 3}}, 11: {{IsOn: true, CertId: 2}, {IsOn: true, CertId: 3}}}, false},
+		{"old certificate left", map[int64][]akiLightCertRef{10: {{IsOn: true, CertId: 1}, {IsOn: true, CertId: 3}}}, true},
+		{"wildcard without new certificate", map[int64][]akiLightCertRef{10: {{IsOn: true, CertId: 3}}, 11: {{IsOn: true, CertId: 2}}}, true},
+		{"new certificate disabled", map[int64][]akiLightCertRef{10: {{IsOn: false, CertId: 3}}}, true},
+		{"unrelated policy", map[int64][]akiLightCertRef{10: {{IsOn: true, CertId: 3}}, 11: {{IsOn: true, CertId: 4}}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &fakeAkiLight{
+				certs: []akiLightCert{
+					{Id: 1, Name: "autocert:www.example.com:old", DNSNames: []string{"www.example.com"}},
+					{Id: 2, Name: "wildcard", DNSNames: []string{"*.example.com"}},
+					{Id: 3, Name: name, DNSNames: []string{"www.example.com"}},
+					{Id: 4, Name: "other", DNSNames: []string{"www.example.org"}},
+				},
+				policies: test.policies,
+			}
+			deployer := newTestAkiLight(t, fake)
+
+			err := deployer.Verify(domain, version)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Verify = %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
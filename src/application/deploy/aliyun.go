@@ -0,0 +1,612 @@
+package deploy
+
+import (
+	"AutoCert/src/application/certstore"
+	"AutoCert/src/utils"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	cas20200407 "github.com/alibabacloud-go/cas-20200407/v3/client"
+	openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
+	util "github.com/alibabacloud-go/tea-utils/v2/service"
+	"github.com/alibabacloud-go/tea/tea"
+)
+
+const (
+	aliyunCDNVersion  = "2018-05-10"
+	aliyunDCDNVersion = "2018-01-15"
+	aliyunSLBVersion  = "2014-05-15"
+
+	// defaultAliyunCASRegion is the region of the CAS service on the China
+	// site, the international site uses ap-southeast-1
+	defaultAliyunCASRegion = "cn-hangzhou"
+)
+
+func init() {
+	Register("aliyun", newAliyunDeployer)
+}
+
+// aliyunDeployer uploads certificates to Aliyun CAS and sets them on the CDN
+// and DCDN domains and SLB HTTPS listeners covered by the certificate.
+// Resources already serving the certificate are left untouched.
+type aliyunDeployer struct {
+	casRegion string
+	cas       *cas20200407.Client
+	// clientConfig returns the client configuration of a product endpoint, tests
+	// point it at a fake server
+	clientConfig func(endpoint string) *openapi.Config
+
+	mu         sync.Mutex
+	clients    map[string]*openapi.Client // endpoint to its client
+	casCertIds map[string]int64           // certificate version to the CAS certificate ID
+	slbCertIds map[string]string          // region and certificate version to the SLB server certificate ID
+}
+
+// aliyunResource is a CDN domain, DCDN domain or SLB listener served by a
+// certificate covering the domain
+type aliyunResource struct {
+	name string
+	// fingerprint of the served certificate, sha1 is set when it is a SHA-1
+	// fingerprint (SLB) instead of SHA-256
+	fingerprint string
+	sha1        bool
+	bind        func(version *certstore.Version) error
+}
+
+func newAliyunDeployer(config utils.Config) (Deployer, error) {
+	if config.Aliyun.AccessKey == "" || config.Aliyun.SecretKey == "" {
+		return nil, fmt.Errorf("aliyun access_key and secret_key are not configured")
+	}
+
+	d := &aliyunDeployer{
+		casRegion: config.Aliyun.CASRegion,
+		clientConfig: func(endpoint string) *openapi.Config {
+			return aliyunClientConfig(config, endpoint)
+		},
+		clients:    make(map[string]*openapi.Client),
+		casCertIds: make(map[string]int64),
+		slbCertIds: make(map[string]string),
+	}
+	if d.casRegion == "" {
+		d.casRegion = defaultAliyunCASRegion
+	}
+
+	cas, err := cas20200407.NewClient(d.clientConfig(aliyunCASEndpoint(d.casRegion)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Aliyun CAS client: %v", err)
+	}
+	d.cas = cas
+	return d, nil
+}
+
+// aliyunCASEndpoint returns the CAS endpoint of the region
+func aliyunCASEndpoint(region string) string {
+	if region == defaultAliyunCASRegion {
+		return "cas.aliyuncs.com"
+	}
+	return fmt.Sprintf("cas.%s.aliyuncs.com", region)
+}
+
+func aliyunClientConfig(config utils.Config, endpoint string) *openapi.Config {
+	return &openapi.Config{
+		AccessKeyId:     tea.String(config.Aliyun.AccessKey),
+		AccessKeySecret: tea.String(config.Aliyun.SecretKey),
+		Endpoint:        tea.String(endpoint),
+	}
+}
+
+// call invokes an RPC style API of the product at endpoint and decodes the response body into result
+func (d *aliyunDeployer) call(endpoint, version, action string, query map[string]string, result interface{}) error {
+	d.mu.Lock()
+	client, ok := d.clients[endpoint]
+	if !ok {
+		var err error
+		client, err = openapi.NewClient(d.clientConfig(endpoint))
+		if err != nil {
+			d.mu.Unlock()
+			return fmt.Errorf("failed to create Aliyun client for %s: %v", endpoint, err)
+		}
+		d.clients[endpoint] = client
+	}
+	d.mu.Unlock()
+
+	params := &openapi.Params{
+		Action:      tea.String(action),
+		Version:     tea.String(version),
+		Protocol:    tea.String("HTTPS"),
+		Pathname:    tea.String("/"),
+		Method:      tea.String("POST"),
+		AuthType:    tea.String("AK"),
+		Style:       tea.String("RPC"),
+		ReqBodyType: tea.String("formData"),
+		BodyType:    tea.String("json"),
+	}
+	request := &openapi.OpenApiRequest{Query: map[string]*string{}}
+	for key, value := range query {
+		request.Query[key] = tea.String(value)
+	}
+
+	response, err := client.CallApi(params, request, &util.RuntimeOptions{})
+	if err != nil {
+		return fmt.Errorf("%s failed: %v", action, err)
+	}
+
+	if result != nil {
+		data, err := json.Marshal(response["body"])
+		if err != nil {
+			return fmt.Errorf("failed to encode %s response: %v", action, err)
+		}
+		if err := json.Unmarshal(data, result); err != nil {
+			return fmt.Errorf("failed to parse %s response: %v", action, err)
+		}
+	}
+	return nil
+}
+
+// casCertId uploads the version to CAS once and returns its certificate ID
+func (d *aliyunDeployer) casCertId(domain string, version *certstore.Version) (int64, error) {
+	d.mu.Lock()
+	certId, ok := d.casCertIds[version.Dir]
+	d.mu.Unlock()
+	if ok {
+		return certId, nil
+	}
+
+	material, err := version.Load()
+	if err != nil {
+		return 0, err
+	}
+
+	request := &cas20200407.UploadUserCertificateRequest{
+		Name: tea.String(certificateName(domain, version)),
+		Cert: tea.String(string(material.FullChainPEM)),
+		Key:  tea.String(string(material.PrivateKeyPEM)),
+	}
+	response, err := d.cas.UploadUserCertificateWithOptions(request, &util.RuntimeOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to upload certificate to CAS: %v", err)
+	}
+	if response.Body == nil || response.Body.CertId == nil {
+		return 0, fmt.Errorf("CAS upload returned no certificate ID")
+	}
+	certId = *response.Body.CertId
+
+	log.Printf("[INFO] Uploaded certificate version %s of domain %s to Aliyun CAS, Cert ID: %d", version.Meta.Version, domain, certId)
+
+	d.mu.Lock()
+	d.casCertIds[version.Dir] = certId
+	d.mu.Unlock()
+	return certId, nil
+}
+
+// resources returns the resources of the configured types served by a certificate covering the domain
+func (d *aliyunDeployer) resources(domain utils.Domain, version *certstore.Version) ([]aliyunResource, error) {
+	resourceTypes := domain.Aliyun.ResourceTypes
+	if len(resourceTypes) == 0 {
+		resourceTypes = []string{"cdn"}
+	}
+
+	var resources []aliyunResource
+	for _, resourceType := range resourceTypes {
+		var found []aliyunResource
+		var err error
+		switch resourceType {
+		case "cdn":
+			found, err = d.cdnResources(domain, version, false)
+		case "dcdn":
+			found, err = d.cdnResources(domain, version, true)
+		case "slb":
+			if len(domain.Aliyun.Regions) == 0 {
+				return nil, fmt.Errorf("slb requires regions to be configured for domain %s", domain.DomainName)
+			}
+			for _, region := range domain.Aliyun.Regions {
+				regionResources, regionErr := d.slbResources(domain, version, region)
+				if regionErr != nil {
+					err = regionErr
+					break
+				}
+				found = append(found, regionResources...)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported Aliyun resource type %q", resourceType)
+		}
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, found...)
+	}
+
+	if len(resources) == 0 {
+		return nil, fmt.Errorf("no Aliyun %v resource matches domain %s", resourceTypes, domain.DomainName)
+	}
+	return resources, nil
+}
+
+// cdnResources returns the online CDN (or DCDN) domains covered by the certificate
+func (d *aliyunDeployer) cdnResources(domain utils.Domain, version *certstore.Version, dcdn bool) ([]aliyunResource, error) {
+	endpoint, apiVersion, product := "cdn.aliyuncs.com", aliyunCDNVersion, "CDN"
+	listAction, infoAction, setAction := "DescribeUserDomains", "DescribeDomainCertificateInfo", "SetCdnDomainSSLCertificate"
+	if dcdn {
+		endpoint, apiVersion, product = "dcdn.aliyuncs.com", aliyunDCDNVersion, "DCDN"
+		listAction, infoAction, setAction = "DescribeDcdnUserDomains", "DescribeDcdnDomainCertificateInfo", "SetDcdnDomainSSLCertificate"
+	}
+
+	var cdnDomains []string
+	for page := 1; ; page++ {
+		var result struct {
+			Domains struct {
+				PageData []struct {
+					DomainName   string `json:"DomainName"`
+					DomainStatus string `json:"DomainStatus"`
+				} `json:"PageData"`
+			} `json:"Domains"`
+			TotalCount json.Number `json:"TotalCount"`
+		}
+		err := d.call(endpoint, apiVersion, listAction, map[string]string{
+			"PageNumber": strconv.Itoa(page),
+			"PageSize":   "500",
+		}, &result)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range result.Domains.PageData {
+			if item.DomainStatus == "online" && coversDomain(version.Meta.DNSNames, item.DomainName) {
+				cdnDomains = append(cdnDomains, item.DomainName)
+			}
+		}
+
+		total, _ := result.TotalCount.Int64()
+		if len(result.Domains.PageData) == 0 || int64(page*500) >= total {
+			break
+		}
+	}
+
+	resources := make([]aliyunResource, 0, len(cdnDomains))
+	for _, cdnDomain := range cdnDomains {
+		var result struct {
+			CertInfos struct {
+				CertInfo []struct {
+					ServerCertificate string `json:"ServerCertificate"`
+					SSLPub            string `json:"SSLPub"`
+				} `json:"CertInfo"`
+			} `json:"CertInfos"`
+		}
+		if err := d.call(endpoint, apiVersion, infoAction, map[string]string{"DomainName": cdnDomain}, &result); err != nil {
+			return nil, err
+		}
+
+		fingerprint := ""
+		for _, info := range result.CertInfos.CertInfo {
+			// CDN returns the certificate as ServerCertificate, DCDN as SSLPub
+			certPEM := info.ServerCertificate
+			if certPEM == "" {
+				certPEM = info.SSLPub
+			}
+			if certPEM != "" {
+				fingerprint = pemFingerprint(certPEM)
+				break
+			}
+		}
+
+		cdnDomain := cdnDomain
+		resources = append(resources, aliyunResource{
+			name:        product + " domain " + cdnDomain,
+			fingerprint: fingerprint,
+			bind: func(version *certstore.Version) error {
+				certId, err := d.casCertId(domain.DomainName, version)
+				if err != nil {
+					return err
+				}
+				return d.call(endpoint, apiVersion, setAction, map[string]string{
+					"DomainName":  cdnDomain,
+					"SSLProtocol": "on",
+					"CertType":    "cas",
+					"CertId":      strconv.FormatInt(certId, 10),
+					"CertName":    certificateName(domain.DomainName, version),
+				}, nil)
+			},
+		})
+	}
+	return resources, nil
+}
+
+type slbServerCertificate struct {
+	ServerCertificateId     string `json:"ServerCertificateId"`
+	Fingerprint             string `json:"Fingerprint"`
+	CommonName              string `json:"CommonName"`
+	SubjectAlternativeNames struct {
+		SubjectAlternativeName []string `json:"SubjectAlternativeName"`
+	} `json:"SubjectAlternativeNames"`
+}
+
+// slbResources returns the HTTPS listeners and their extension domains in
+// the region whose certificate covers the domain and can be replaced by the
+// version. A certificate with names the version lacks, such as a shared
+// wildcard replaced by a single-name certificate, is left alone.
+func (d *aliyunDeployer) slbResources(domain utils.Domain, version *certstore.Version, region string) ([]aliyunResource, error) {
+	endpoint := fmt.Sprintf("slb.%s.aliyuncs.com", region)
+
+	var certResult struct {
+		ServerCertificates struct {
+			ServerCertificate []slbServerCertificate `json:"ServerCertificate"`
+		} `json:"ServerCertificates"`
+	}
+	if err := d.call(endpoint, aliyunSLBVersion, "DescribeServerCertificates", map[string]string{"RegionId": region}, &certResult); err != nil {
+		return nil, err
+	}
+	serverCerts := make(map[string]slbServerCertificate)
+	for _, cert := range certResult.ServerCertificates.ServerCertificate {
+		serverCerts[cert.ServerCertificateId] = cert
+	}
+	servesDomain := func(certId string) bool {
+		cert, ok := serverCerts[certId]
+		if !ok {
+			return false
+		}
+		names := append([]string{cert.CommonName}, cert.SubjectAlternativeNames.SubjectAlternativeName...)
+		if !coversDomain(names, domain.DomainName) {
+			return false
+		}
+		if !coversAll(version.Meta.DNSNames, names) {
+			log.Printf("[INFO] Keeping SLB server certificate %s, it covers %v beyond the new certificate", certId, names)
+			return false
+		}
+		return true
+	}
+
+	var loadBalancerIds []string
+	for page := 1; ; page++ {
+		var result struct {
+			LoadBalancers struct {
+				LoadBalancer []struct {
+					LoadBalancerId string `json:"LoadBalancerId"`
+				} `json:"LoadBalancer"`
+			} `json:"LoadBalancers"`
+			TotalCount json.Number `json:"TotalCount"`
+		}
+		err := d.call(endpoint, aliyunSLBVersion, "DescribeLoadBalancers", map[string]string{
+			"RegionId":   region,
+			"PageNumber": strconv.Itoa(page),
+			"PageSize":   "100",
+		}, &result)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, loadBalancer := range result.LoadBalancers.LoadBalancer {
+			loadBalancerIds = append(loadBalancerIds, loadBalancer.LoadBalancerId)
+		}
+
+		total, _ := result.TotalCount.Int64()
+		if len(result.LoadBalancers.LoadBalancer) == 0 || int64(page*100) >= total {
+			break
+		}
+	}
+
+	var resources []aliyunResource
+	for _, loadBalancerId := range loadBalancerIds {
+		var attribute struct {
+			ListenerPortsAndProtocol struct {
+				ListenerPortAndProtocol []struct {
+					ListenerPort     int    `json:"ListenerPort"`
+					ListenerProtocol string `json:"ListenerProtocol"`
+				} `json:"ListenerPortAndProtocol"`
+			} `json:"ListenerPortsAndProtocol"`
+		}
+		err := d.call(endpoint, aliyunSLBVersion, "DescribeLoadBalancerAttribute", map[string]string{
+			"RegionId":       region,
+			"LoadBalancerId": loadBalancerId,
+		}, &attribute)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, listener := range attribute.ListenerPortsAndProtocol.ListenerPortAndProtocol {
+			if !strings.EqualFold(listener.ListenerProtocol, "https") {
+				continue
+			}
+			port := strconv.Itoa(listener.ListenerPort)
+
+			var https struct {
+				ServerCertificateId string `json:"ServerCertificateId"`
+				DomainExtensions    struct {
+					DomainExtension []struct {
+						DomainExtensionId   string `json:"DomainExtensionId"`
+						Domain              string `json:"Domain"`
+						ServerCertificateId string `json:"ServerCertificateId"`
+					} `json:"DomainExtension"`
+				} `json:"DomainExtensions"`
+			}
+			err := d.call(endpoint, aliyunSLBVersion, "DescribeLoadBalancerHTTPSListenerAttribute", map[string]string{
+				"RegionId":       region,
+				"LoadBalancerId": loadBalancerId,
+				"ListenerPort":   port,
+			}, &https)
+			if err != nil {
+				return nil, err
+			}
+
+			if servesDomain(https.ServerCertificateId) {
+				loadBalancerId := loadBalancerId
+				resources = append(resources, aliyunResource{
+					name:        fmt.Sprintf("SLB listener %s:%s (%s)", loadBalancerId, port, region),
+					fingerprint: serverCerts[https.ServerCertificateId].Fingerprint,
+					sha1:        true,
+					bind: func(version *certstore.Version) error {
+						certId, err := d.slbCertId(domain.DomainName, region, version)
+						if err != nil {
+							return err
+						}
+						return d.call(endpoint, aliyunSLBVersion, "SetLoadBalancerHTTPSListenerAttribute", map[string]string{
+							"RegionId":            region,
+							"LoadBalancerId":      loadBalancerId,
+							"ListenerPort":        port,
+							"ServerCertificateId": certId,
+						}, nil)
+					},
+				})
+			}
+
+			for _, extension := range https.DomainExtensions.DomainExtension {
+				if !servesDomain(extension.ServerCertificateId) {
+					continue
+				}
+				extensionId := extension.DomainExtensionId
+				resources = append(resources, aliyunResource{
+					name:        fmt.Sprintf("SLB listener %s:%s domain %s (%s)", loadBalancerId, port, extension.Domain, region),
+					fingerprint: serverCerts[extension.ServerCertificateId].Fingerprint,
+					sha1:        true,
+					bind: func(version *certstore.Version) error {
+						certId, err := d.slbCertId(domain.DomainName, region, version)
+						if err != nil {
+							return err
+						}
+						return d.call(endpoint, aliyunSLBVersion, "SetDomainExtensionAttribute", map[string]string{
+							"RegionId":            region,
+							"DomainExtensionId":   extensionId,
+							"ServerCertificateId": certId,
+						}, nil)
+					},
+				})
+			}
+		}
+	}
+	return resources, nil
+}
+
+// slbCertId imports the CAS certificate of the version into SLB once per region
+func (d *aliyunDeployer) slbCertId(domain, region string, version *certstore.Version) (string, error) {
+	key := region + "|" + version.Dir
+	d.mu.Lock()
+	certId, ok := d.slbCertIds[key]
+	d.mu.Unlock()
+	if ok {
+		return certId, nil
+	}
+
+	casCertId, err := d.casCertId(domain, version)
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		ServerCertificateId string `json:"ServerCertificateId"`
+	}
+	err = d.call(fmt.Sprintf("slb.%s.aliyuncs.com", region), aliyunSLBVersion, "UploadServerCertificate", map[string]string{
+		"RegionId":                    region,
+		"AliCloudCertificateId":       strconv.FormatInt(casCertId, 10),
+		"AliCloudCertificateName":     certificateName(domain, version),
+		"AliCloudCertificateRegionId": d.casRegion,
+		"ServerCertificateName":       certificateName(domain, version),
+	}, &result)
+	if err != nil {
+		return "", err
+	}
+	if result.ServerCertificateId == "" {
+		return "", fmt.Errorf("SLB certificate upload returned no certificate ID")
+	}
+
+	log.Printf("[INFO] Imported certificate version %s of domain %s into SLB %s, Server Certificate ID: %s", version.Meta.Version, domain, region, result.ServerCertificateId)
+
+	d.mu.Lock()
+	d.slbCertIds[key] = result.ServerCertificateId
+	d.mu.Unlock()
+	return result.ServerCertificateId, nil
+}
+
+// serves reports whether the resource already uses the certificate of the version
+func (r aliyunResource) serves(version *certstore.Version) (bool, error) {
+	if r.fingerprint == "" {
+		return false, nil
+	}
+	if !r.sha1 {
+		return strings.EqualFold(r.fingerprint, version.Meta.Fingerprint), nil
+	}
+
+	material, err := version.Load()
+	if err != nil {
+		return false, err
+	}
+	block, _ := pem.Decode(material.CertificatePEM)
+	if block == nil {
+		return false, fmt.Errorf("no certificate found in %s", version.CertPath())
+	}
+	sum := sha1.Sum(block.Bytes)
+	return normalizeFingerprint(r.fingerprint) == hex.EncodeToString(sum[:]), nil
+}
+
+// pemFingerprint returns the SHA-256 fingerprint of the first certificate in certPEM
+func pemFingerprint(certPEM string) string {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		return ""
+	}
+	sum := sha256.Sum256(block.Bytes)
+	return hex.EncodeToString(sum[:])
+}
+
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+}
+
+func (d *aliyunDeployer) Deploy(domain utils.Domain, version *certstore.Version) error {
+	resources, err := d.resources(domain, version)
+	if err != nil {
+		return err
+	}
+
+	failed := []string{}
+	for _, resource := range resources {
+		serves, err := resource.serves(version)
+		if err != nil {
+			return err
+		}
+		if serves {
+			log.Printf("[INFO] Aliyun %s already serves certificate version %s", resource.name, version.Meta.Version)
+			continue
+		}
+
+		log.Printf("[INFO] Setting certificate version %s on Aliyun %s", version.Meta.Version, resource.name)
+		if err := resource.bind(version); err != nil {
+			log.Printf("[ERROR] Failed to set certificate on Aliyun %s: %v", resource.name, err)
+			failed = append(failed, resource.name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to set certificate on %d of %d Aliyun resources: %s", len(failed), len(resources), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// Verify checks that every matching resource serves the certificate of the version
+func (d *aliyunDeployer) Verify(domain utils.Domain, version *certstore.Version) error {
+	resources, err := d.resources(domain, version)
+	if err != nil {
+		return err
+	}
+
+	for _, resource := range resources {
+		serves, err := resource.serves(version)
+		if err != nil {
+			return err
+		}
+		if !serves {
+			return fmt.Errorf("Aliyun %s does not serve certificate version %s", resource.name, version.Meta.Version)
+		}
+	}
+	return nil
+}
+
+func (d *aliyunDeployer) Rollback(domain utils.Domain, previous *certstore.Version) error {
+	return d.Deploy(domain, previous)
+}
@@ -0,0 +1,310 @@
+package deploy
+
+import (
+	"AutoCert/src/application/certstore"
+	"AutoCert/src/utils"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cas20200407 "github.com/alibabacloud-go/cas-20200407/v3/client"
+	openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
+	"github.com/alibabacloud-go/tea/tea"
+)
+
+// fakeSLBCert is a server certificate of the fake SLB
+type fakeSLBCert struct {
+	names       []string
+	fingerprint string
+}
+
+// fakeSLBExtension is a domain extension of the HTTPS listener lb-1:443
+type fakeSLBExtension struct {
+	domain string
+	certId string
+}
+
+// fakeAliyun serves the CAS, CDN, DCDN and SLB actions used by the deployer.
+// SLB has a single load balancer lb-1 with an HTTPS listener on 443.
+type fakeAliyun struct {
+	mu          sync.Mutex
+	cdn         map[string]string // online CDN domain to the PEM it serves
+	dcdn        map[string]string // online DCDN domain to the PEM it serves
+	casCerts    map[string]string // CAS certificate ID to its PEM
+	serverCerts map[string]fakeSLBCert
+	listener    string // server certificate ID of lb-1:443
+	extensions  map[string]*fakeSLBExtension
+	nextId      int
+	casRegions  []string // AliCloudCertificateRegionId of the SLB imports
+}
+
+func (f *fakeAliyun) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	param := r.FormValue
+
+	var body interface{}
+	switch action := r.Header.Get("x-acs-action"); action {
+	case "UploadUserCertificate":
+		f.nextId++
+		id := strconv.Itoa(f.nextId)
+		f.casCerts[id] = param("Cert")
+		body = map[string]interface{}{"CertId": f.nextId}
+	case "DescribeUserDomains", "DescribeDcdnUserDomains":
+		domains := f.cdn
+		if action == "DescribeDcdnUserDomains" {
+			domains = f.dcdn
+		}
+		pageData := []interface{}{}
+		for name := range domains {
+			pageData = append(pageData, map[string]string{"DomainName": name, "DomainStatus": "online"})
+		}
+		body = map[string]interface{}{"Domains": map[string]interface{}{"PageData": pageData}, "TotalCount": len(pageData)}
+	case "DescribeDomainCertificateInfo":
+		body = map[string]interface{}{"CertInfos": map[string]interface{}{"CertInfo": []interface{}{
+			map[string]string{"ServerCertificate": f.cdn[param("DomainName")]},
+		}}}
+	case "DescribeDcdnDomainCertificateInfo":
+		body = map[string]interface{}{"CertInfos": map[string]interface{}{"CertInfo": []interface{}{
+			map[string]string{"SSLPub": f.dcdn[param("DomainName")]},
+		}}}
+	case "SetCdnDomainSSLCertificate":
+		f.cdn[param("DomainName")] = f.casCerts[param("CertId")]
+	case "SetDcdnDomainSSLCertificate":
+		f.dcdn[param("DomainName")] = f.casCerts[param("CertId")]
+	case "DescribeServerCertificates":
+		certs := []interface{}{}
+		for id, cert := range f.serverCerts {
+			certs = append(certs, map[string]interface{}{
+				"ServerCertificateId":     id,
+				"Fingerprint":             cert.fingerprint,
+				"CommonName":              cert.names[0],
+				"SubjectAlternativeNames": map[string]interface{}{"SubjectAlternativeName": cert.names[1:]},
+			})
+		}
+		body = map[string]interface{}{"ServerCertificates": map[string]interface{}{"ServerCertificate": certs}}
+	case "DescribeLoadBalancers":
+		body = map[string]interface{}{
+			"LoadBalancers": map[string]interface{}{"LoadBalancer": []interface{}{map[string]string{"LoadBalancerId": "lb-1"}}},
+			"TotalCount":    1,
+		}
+	case "DescribeLoadBalancerAttribute":
+		body = map[string]interface{}{"ListenerPortsAndProtocol": map[string]interface{}{"ListenerPortAndProtocol": []interface{}{
+			map[string]interface{}{"ListenerPort": 80, "ListenerProtocol": "http"},
+			map[string]interface{}{"ListenerPort": 443, "ListenerProtocol": "https"},
+		}}}
+	case "DescribeLoadBalancerHTTPSListenerAttribute":
+		extensions := []interface{}{}
+		for id, extension := range f.extensions {
+			extensions = append(extensions, map[string]string{"DomainExtensionId": id, "Domain": extension.domain, "ServerCertificateId": extension.certId})
+		}
+		body = map[string]interface{}{
+			"ServerCertificateId": f.listener,
+			"DomainExtensions":    map[string]interface{}{"DomainExtension": extensions},
+		}
+	case "UploadServerCertificate":
+		cert, err := parseTestCert(f.casCerts[param("AliCloudCertificateId")])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.nextId++
+		id := "sc-" + strconv.Itoa(f.nextId)
+		sum := sha1.Sum(cert.Raw)
+		f.serverCerts[id] = fakeSLBCert{names: cert.DNSNames, fingerprint: colonHex(sum[:])}
+		f.casRegions = append(f.casRegions, param("AliCloudCertificateRegionId"))
+		body = map[string]string{"ServerCertificateId": id}
+	case "SetLoadBalancerHTTPSListenerAttribute":
+		f.listener = param("ServerCertificateId")
+	case "SetDomainExtensionAttribute":
+		f.extensions[param("DomainExtensionId")].certId = param("ServerCertificateId")
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"Code": "InvalidAction", "Message": action})
+		return
+	}
+
+	if body == nil {
+		body = map[string]string{}
+	}
+	json.NewEncoder(w).Encode(body)
+}
+
+func parseTestCert(certPEM string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		return nil, fmt.Errorf("no certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// colonHex formats a fingerprint the way SLB returns it
+func colonHex(sum []byte) string {
+	parts := make([]string, len(sum))
+	for idx, b := range sum {
+		parts[idx] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// newTestAliyun returns a deployer sending the requests of every product to the fake
+func newTestAliyun(t *testing.T, fake *fakeAliyun, config utils.Config) *aliyunDeployer {
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	config.Aliyun.AccessKey = "test-id"
+	config.Aliyun.SecretKey = "test-secret"
+	deployer, err := newAliyunDeployer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := deployer.(*aliyunDeployer)
+	d.clientConfig = func(endpoint string) *openapi.Config {
+		clientConfig := aliyunClientConfig(config, strings.TrimPrefix(server.URL, "http://"))
+		clientConfig.Protocol = tea.String("HTTP")
+		return clientConfig
+	}
+	if d.cas, err = cas20200407.NewClient(d.clientConfig(aliyunCASEndpoint(d.casRegion))); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// servedBy returns the names of a server certificate of the fake
+func servedBy(fake *fakeAliyun, certId string) []string {
+	return fake.serverCerts[certId].names
+}
+
+func TestAliyunDeployVerifyAndRollback(t *testing.T) {
+	certs := certstore.New(t.TempDir(), 5)
+	domain := utils.Domain{DomainName: "www.example.com"}
+	domain.Aliyun.ResourceTypes = []string{"cdn", "dcdn", "slb"}
+	domain.Aliyun.Regions = []string{"cn-hangzhou"}
+
+	previous := testVersion(t, certs, domain.DomainName, time.Now().Add(60*24*time.Hour), domain.DomainName)
+	version := testVersion(t, certs, domain.DomainName, time.Now().Add(90*24*time.Hour), domain.DomainName)
+	previousMaterial, err := previous.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeAliyun{
+		cdn:      map[string]string{"www.example.com": string(previousMaterial.CertificatePEM), "api.example.com": ""},
+		dcdn:     map[string]string{"www.example.com": ""},
+		casCerts: map[string]string{},
+		serverCerts: map[string]fakeSLBCert{
+			"sc-www":      {names: []string{"www.example.com"}, fingerprint: "00:11"},
+			"sc-wildcard": {names: []string{"*.example.com"}, fingerprint: "00:22"},
+			"sc-other":    {names: []string{"www.example.org"}, fingerprint: "00:33"},
+		},
+		listener: "sc-www",
+		extensions: map[string]*fakeSLBExtension{
+			"ext-www":      {domain: "www.example.com", certId: "sc-www"},
+			"ext-wildcard": {domain: "api.example.com", certId: "sc-wildcard"},
+			"ext-other":    {domain: "www.example.org", certId: "sc-other"},
+		},
+		nextId: 100,
+	}
+	deployer := newTestAliyun(t, fake, utils.Config{})
+
+	if err := deployer.Verify(domain, version); err == nil {
+		t.Fatal("Verify succeeded before the deployment")
+	}
+	if err := deployer.Deploy(domain, version); err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if err := deployer.Verify(domain, version); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	material, err := version.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.cdn["www.example.com"] != string(material.FullChainPEM) || fake.dcdn["www.example.com"] != string(material.FullChainPEM) {
+		t.Errorf("CDN and DCDN domains were not switched to the version")
+	}
+	if fake.cdn["api.example.com"] != "" {
+		t.Errorf("CDN domain not covered by the certificate was bound")
+	}
+	if names := servedBy(fake, fake.listener); fake.listener == "sc-www" || len(names) != 1 || names[0] != "www.example.com" {
+		t.Errorf("listener uses %s %v, want the imported version", fake.listener, names)
+	}
+	if fake.extensions["ext-www"].certId != fake.listener {
+		t.Errorf("extension of www uses %s, want %s", fake.extensions["ext-www"].certId, fake.listener)
+	}
+	// The shared wildcard covers more than the version and stays on its extension
+	if fake.extensions["ext-wildcard"].certId != "sc-wildcard" || fake.extensions["ext-other"].certId != "sc-other" {
+		t.Errorf("extensions of other certificates were switched: %v %v", fake.extensions["ext-wildcard"], fake.extensions["ext-other"])
+	}
+	if len(fake.casRegions) != 1 || fake.casRegions[0] != defaultAliyunCASRegion {
+		t.Errorf("imported from CAS regions %v, want a single import from %s", fake.casRegions, defaultAliyunCASRegion)
+	}
+
+	// Rollback switches every resource back to the previous version
+	if err := deployer.Rollback(domain, previous); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	if err := deployer.Verify(domain, previous); err != nil {
+		t.Fatalf("Verify after Rollback: %v", err)
+	}
+	if err := deployer.Verify(domain, version); err == nil {
+		t.Error("Verify of the rolled back version succeeded")
+	}
+}
+
+func TestAliyunVerifyDetectsOtherCertificate(t *testing.T) {
+	certs := certstore.New(t.TempDir(), 5)
+	domain := utils.Domain{DomainName: "www.example.com"}
+	domain.Aliyun.ResourceTypes = []string{"slb"}
+	domain.Aliyun.Regions = []string{"ap-southeast-1"}
+	version := testVersion(t, certs, domain.DomainName, time.Now().Add(90*24*time.Hour), domain.DomainName)
+
+	fake := &fakeAliyun{
+		casCerts:    map[string]string{},
+		serverCerts: map[string]fakeSLBCert{"sc-www": {names: []string{"www.example.com"}, fingerprint: "00:11"}},
+		listener:    "sc-www",
+		extensions:  map[string]*fakeSLBExtension{"ext-www": {domain: "www.example.com", certId: "sc-www"}},
+	}
+	var config utils.Config
+	config.Aliyun.CASRegion = "ap-southeast-1"
+	deployer := newTestAliyun(t, fake, config)
+
+	if err := deployer.Deploy(domain, version); err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if len(fake.casRegions) != 1 || fake.casRegions[0] != "ap-southeast-1" {
+		t.Errorf("imported from CAS regions %v, want ap-southeast-1", fake.casRegions)
+	}
+
+	if fake.extensions["ext-www"].certId == "sc-www" {
+		t.Fatal("Deploy did not switch the extension")
+	}
+	fake.extensions["ext-www"].certId = "sc-www"
+	if err := deployer.Verify(domain, version); err == nil {
+		t.Fatal("Verify succeeded although an extension serves the old certificate")
+	}
+}
+
+func TestAliyunCASEndpoint(t *testing.T) {
+	if endpoint := aliyunCASEndpoint("cn-hangzhou"); endpoint != "cas.aliyuncs.com" {
+		t.Errorf("aliyunCASEndpoint(cn-hangzhou) = %s", endpoint)
+	}
+	if endpoint := aliyunCASEndpoint("ap-southeast-1"); endpoint != "cas.ap-southeast-1.aliyuncs.com" {
+		t.Errorf("aliyunCASEndpoint(ap-southeast-1) = %s", endpoint)
+	}
+}
@@ -1 +1,343 @@
 package deploy
+
+import (
+	"AutoCert/src/application/certstore"
+	"AutoCert/src/utils"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultBaishanEndpoint = "https://cdn.api.baishan.com"
+
+func init() {
+	Register("baishan", newBaishanDeployer)
+}
+
+// baishanDeployer uploads certificates to Baishan CDN and binds them to the
+// CDN domains covered by the configured domain_name
+type baishanDeployer struct {
+	endpoint string
+	token    string
+	client   *http.Client
+
+	mu      sync.Mutex
+	certIds map[string]string // certificate version to the Baishan certificate ID
+}
+
+func newBaishanDeployer(config utils.Config) (Deployer, error) {
+	if config.Baishan.Token == "" {
+		return nil, fmt.Errorf("baishan token is not configured")
+	}
+
+	endpoint := config.Baishan.Endpoint
+	if endpoint == "" {
+		endpoint = defaultBaishanEndpoint
+	}
+	return &baishanDeployer{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		token:    config.Baishan.Token,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		certIds:  make(map[string]string),
+	}, nil
+}
+
+type baishanResponse struct {
+	Code int             `json:"code"`
+	Msg  string          `json:"msg"`
+	Data json.RawMessage `json:"data"`
+}
+
+// call sends a request to the Baishan API, body is JSON encoded when not nil
+func (d *baishanDeployer) call(method, path string, query url.Values, body interface{}, result interface{}) error {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("token", d.token)
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s request: %v", path, err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	request, err := http.NewRequest(method, d.endpoint+path+"?"+query.Encode(), reader)
+	if err != nil {
+		return fmt.Errorf("failed to create %s request: %v", path, err)
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := d.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read %s response: %v", path, err)
+	}
+
+	var apiResp baishanResponse
+	if err := json.Unmarshal(respBody, &apiResp); err != nil {
+		return fmt.Errorf("failed to parse %s response (HTTP %d): %v", path, resp.StatusCode, err)
+	}
+	if apiResp.Code != 0 {
+		return fmt.Errorf("%s failed with code %d: %s", path, apiResp.Code, apiResp.Msg)
+	}
+
+	// Numbers are kept as json.Number so IDs are not rounded through float64
+	if result != nil && len(apiResp.Data) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(apiResp.Data))
+		decoder.UseNumber()
+		if err := decoder.Decode(result); err != nil {
+			return fmt.Errorf("failed to parse %s data: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// findCert returns the certificate ID of the version uploaded by this or an
+// earlier run, or an empty ID when the version was not uploaded
+func (d *baishanDeployer) findCert(domain string, version *certstore.Version) (string, error) {
+	d.mu.Lock()
+	certId, ok := d.certIds[version.Dir]
+	d.mu.Unlock()
+	if ok {
+		return certId, nil
+	}
+
+	name := certificateName(domain, version)
+	for page := 1; ; page++ {
+		query := url.Values{}
+		query.Set("page_number", strconv.Itoa(page))
+		query.Set("page_size", "500")
+
+		var result struct {
+			List []struct {
+				CertId json.Number `json:"cert_id"`
+				Name   string      `json:"name"`
+			} `json:"list"`
+			Total json.Number `json:"total"`
+		}
+		if err := d.call(http.MethodGet, "/v2/domain/certificate", query, nil, &result); err != nil {
+			return "", err
+		}
+
+		for _, item := range result.List {
+			if item.Name == name && item.CertId != "" {
+				d.mu.Lock()
+				d.certIds[version.Dir] = item.CertId.String()
+				d.mu.Unlock()
+				return item.CertId.String(), nil
+			}
+		}
+
+		total, _ := result.Total.Int64()
+		if len(result.List) == 0 || int64(page*500) >= total {
+			return "", nil
+		}
+	}
+}
+
+// uploadCert uploads the version once and returns its certificate ID
+func (d *baishanDeployer) uploadCert(domain string, version *certstore.Version) (string, error) {
+	certId, err := d.findCert(domain, version)
+	if err != nil || certId != "" {
+		return certId, err
+	}
+
+	material, err := version.Load()
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		CertId json.Number `json:"cert_id"`
+	}
+	err = d.call(http.MethodPost, "/v2/domain/certificate", nil, map[string]string{
+		"certificate": string(material.FullChainPEM),
+		"key":         string(material.PrivateKeyPEM),
+		"name":        certificateName(domain, version),
+	}, &result)
+	if err != nil {
+		return "", err
+	}
+	if result.CertId == "" {
+		return "", fmt.Errorf("certificate upload returned no certificate ID")
+	}
+
+	log.Printf("[INFO] Uploaded certificate version %s of domain %s to Baishan, Cert ID: %s", version.Meta.Version, domain, result.CertId)
+
+	d.mu.Lock()
+	d.certIds[version.Dir] = result.CertId.String()
+	d.mu.Unlock()
+	return result.CertId.String(), nil
+}
+
+// matchingDomains returns the serving CDN domains covered by domain
+func (d *baishanDeployer) matchingDomains(domain string) ([]string, error) {
+	var matched []string
+	for page := 1; ; page++ {
+		query := url.Values{}
+		query.Set("page_number", strconv.Itoa(page))
+		query.Set("page_size", "500")
+
+		var result struct {
+			List []struct {
+				Domain string `json:"domain"`
+				Status string `json:"status"`
+			} `json:"list"`
+			Total json.Number `json:"total"`
+		}
+		if err := d.call(http.MethodGet, "/v2/domain/list", query, nil, &result); err != nil {
+			return nil, err
+		}
+
+		for _, item := range result.List {
+			// Suspended and deleted CDN domains are left alone
+			if item.Status != "serving" {
+				continue
+			}
+			if coversDomain([]string{domain}, item.Domain) {
+				matched = append(matched, item.Domain)
+			}
+		}
+
+		total, _ := result.Total.Int64()
+		if len(result.List) == 0 || int64(page*500) >= total {
+			break
+		}
+	}
+
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("no Baishan CDN domain matches %s", domain)
+	}
+	return matched, nil
+}
+
+// httpsConfigs returns the current https configuration of each CDN domain
+func (d *baishanDeployer) httpsConfigs(domains []string) (map[string]map[string]interface{}, error) {
+	query := url.Values{}
+	query.Set("domains", strings.Join(domains, ","))
+	query.Add("config[]", "https")
+
+	var result []struct {
+		Domain string `json:"domain"`
+		Config struct {
+			HTTPS map[string]interface{} `json:"https"`
+		} `json:"config"`
+	}
+	if err := d.call(http.MethodGet, "/v2/domain/config", query, nil, &result); err != nil {
+		return nil, err
+	}
+
+	configs := make(map[string]map[string]interface{})
+	for _, item := range result {
+		configs[item.Domain] = item.Config.HTTPS
+	}
+	return configs, nil
+}
+
+// bind sets certId on the CDN domains while keeping the rest of their https settings
+func (d *baishanDeployer) bind(domains []string, certId string) error {
+	configs, err := d.httpsConfigs(domains)
+	if err != nil {
+		return err
+	}
+
+	for _, cdnDomain := range domains {
+		https := configs[cdnDomain]
+		if https == nil {
+			https = map[string]interface{}{}
+		}
+		if baishanCertId(https["cert_id"]) == certId {
+			log.Printf("[INFO] Baishan CDN domain %s already uses certificate %s", cdnDomain, certId)
+			continue
+		}
+		https["cert_id"] = certId
+
+		log.Printf("[INFO] Binding certificate %s to Baishan CDN domain %s", certId, cdnDomain)
+		err := d.call(http.MethodPost, "/v2/domain/config", nil, map[string]interface{}{
+			"domains": []string{cdnDomain},
+			"config":  map[string]interface{}{"https": https},
+		}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *baishanDeployer) Deploy(domain utils.Domain, version *certstore.Version) error {
+	domains, err := d.matchingDomains(domain.DomainName)
+	if err != nil {
+		return err
+	}
+
+	certId, err := d.uploadCert(domain.DomainName, version)
+	if err != nil {
+		return err
+	}
+	return d.bind(domains, certId)
+}
+
+// Verify checks that every matching CDN domain is bound to the uploaded
+// certificate of the version, without uploading it
+func (d *baishanDeployer) Verify(domain utils.Domain, version *certstore.Version) error {
+	domains, err := d.matchingDomains(domain.DomainName)
+	if err != nil {
+		return err
+	}
+
+	certId, err := d.findCert(domain.DomainName, version)
+	if err != nil {
+		return err
+	}
+	if certId == "" {
+		return fmt.Errorf("certificate version %s of domain %s was not uploaded to Baishan", version.Meta.Version, domain.DomainName)
+	}
+
+	configs, err := d.httpsConfigs(domains)
+	if err != nil {
+		return err
+	}
+	for _, cdnDomain := range domains {
+		if bound := baishanCertId(configs[cdnDomain]["cert_id"]); bound != certId {
+			return fmt.Errorf("Baishan CDN domain %s uses certificate %s instead of %s", cdnDomain, bound, certId)
+		}
+	}
+	return nil
+}
+
+func (d *baishanDeployer) Rollback(domain utils.Domain, previous *certstore.Version) error {
+	return d.Deploy(domain, previous)
+}
+
+// baishanCertId normalises a cert_id from the https configuration, the API
+// returns it either as a number or as a string
+func baishanCertId(value interface{}) string {
+	switch id := value.(type) {
+	case nil:
+		return ""
+	case json.Number:
+		return id.String()
+	case string:
+		return strings.TrimSpace(id)
+	default:
+		return fmt.Sprint(id)
+	}
+}
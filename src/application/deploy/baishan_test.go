@@ -0,0 +1,234 @@
+package deploy
+
+import (
+	"AutoCert/src/application/certstore"
+	"AutoCert/src/utils"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBaishan serves the parts of the Baishan CDN API used by the deployer
+type fakeBaishan struct {
+	mu      sync.Mutex
+	domains []string
+	paused  []string // CDN domains listed as suspended
+	https   map[string]map[string]interface{}
+	certs   map[int64]string // uploaded certificate IDs to their names
+	nextId  int64
+	uploads int
+	binds   int
+}
+
+func (f *fakeBaishan) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Query().Get("token") != "test-token" {
+		fmt.Fprint(w, `{"code":401,"msg":"invalid token"}`)
+		return
+	}
+
+	var data interface{}
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v2/domain/certificate":
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.uploads++
+		f.nextId++
+		if f.certs == nil {
+			f.certs = map[int64]string{}
+		}
+		f.certs[f.nextId] = body["name"]
+		// IDs beyond 1e6 print in exponent form when decoded through float64
+		data = map[string]interface{}{"cert_id": f.nextId}
+	case r.Method == http.MethodGet && r.URL.Path == "/v2/domain/certificate":
+		list := []map[string]interface{}{}
+		for id, name := range f.certs {
+			list = append(list, map[string]interface{}{"cert_id": id, "name": name})
+		}
+		data = map[string]interface{}{"list": list, "total": len(list)}
+	case r.Method == http.MethodGet && r.URL.Path == "/v2/domain/list":
+		list := []map[string]string{}
+		for _, domain := range f.domains {
+			list = append(list, map[string]string{"domain": domain, "status": "serving"})
+		}
+		for _, domain := range f.paused {
+			list = append(list, map[string]string{"domain": domain, "status": "suspend"})
+		}
+		data = map[string]interface{}{"list": list, "total": len(list)}
+	case r.Method == http.MethodGet && r.URL.Path == "/v2/domain/config":
+		configs := []interface{}{}
+		for _, domain := range strings.Split(r.URL.Query().Get("domains"), ",") {
+			configs = append(configs, map[string]interface{}{
+				"domain": domain,
+				"config": map[string]interface{}{"https": f.https[domain]},
+			})
+		}
+		data = configs
+	case r.Method == http.MethodPost && r.URL.Path == "/v2/domain/config":
+		var body struct {
+			Domains []string `json:"domains"`
+			Config  struct {
+				HTTPS map[string]interface{} `json:"https"`
+			} `json:"config"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.binds++
+		for _, domain := range body.Domains {
+			// The API stores the ID as a number whatever type it was sent as
+			var certId int64
+			fmt.Sscan(fmt.Sprint(body.Config.HTTPS["cert_id"]), &certId)
+			body.Config.HTTPS["cert_id"] = certId
+			f.https[domain] = body.Config.HTTPS
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"code": 0, "data": data})
+}
+
+func newTestBaishan(t *testing.T, fake *fakeBaishan) *baishanDeployer {
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	var config utils.Config
+	config.Baishan.Token = "test-token"
+	config.Baishan.Endpoint = server.URL
+	deployer, err := newBaishanDeployer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return deployer.(*baishanDeployer)
+}
+
+func TestBaishanDeployAndVerify(t *testing.T) {
+	fake := &fakeBaishan{
+		domains: []string{"www.example.com", "static.example.com", "www.example.org"},
+		paused:  []string{"old.example.com"},
+		https: map[string]map[string]interface{}{
+			"www.example.com":    {"cert_id": 42, "force_https": "1"},
+			"static.example.com": {},
+		},
+		nextId: 1234566,
+	}
+	deployer := newTestBaishan(t, fake)
+
+	certs := certstore.New(t.TempDir(), 5)
+	domain := utils.Domain{DomainName: "*.example.com"}
+	version := testVersion(t, certs, domain.DomainName, time.Now().Add(90*24*time.Hour), "*.example.com")
+
+	if err := deployer.Deploy(domain, version); err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if err := deployer.Verify(domain, version); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if fake.binds != 2 {
+		t.Fatalf("bound %d CDN domains, want 2", fake.binds)
+	}
+	if fake.https["www.example.com"]["force_https"] != "1" {
+		t.Errorf("other https settings were not kept: %v", fake.https["www.example.com"])
+	}
+	for _, other := range []string{"www.example.org", "old.example.com"} {
+		if _, ok := fake.https[other]; ok {
+			t.Errorf("CDN domain %s was bound", other)
+		}
+	}
+
+	// A second deployment of the same version finds the bindings in place
+	if err := deployer.Deploy(domain, version); err != nil {
+		t.Fatalf("second Deploy: %v", err)
+	}
+	if fake.binds != 2 || fake.uploads != 1 {
+		t.Errorf("second Deploy uploaded %d times and bound %d times, want 1 and 2", fake.uploads, fake.binds)
+	}
+}
+
+// Verify in a later run finds the uploaded certificate by name and never uploads one itself
+func TestBaishanVerifyIsReadOnly(t *testing.T) {
+	fake := &fakeBaishan{
+		domains: []string{"www.example.com"},
+		https:   map[string]map[string]interface{}{"www.example.com": {}},
+	}
+
+	certs := certstore.New(t.TempDir(), 5)
+	domain := utils.Domain{DomainName: "www.example.com"}
+	version := testVersion(t, certs, domain.DomainName, time.Now().Add(90*24*time.Hour), domain.DomainName)
+	other := testVersion(t, certs, domain.DomainName, time.Now().Add(80*24*time.Hour), domain.DomainName)
+
+	if err := newTestBaishan(t, fake).Verify(domain, version); err == nil {
+		t.Fatal("Verify succeeded for a version that was never uploaded")
+	}
+	if err := newTestBaishan(t, fake).Deploy(domain, version); err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+
+	deployer := newTestBaishan(t, fake)
+	if err := deployer.Verify(domain, version); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if err := deployer.Verify(domain, other); err == nil {
+		t.Error("Verify succeeded for a version that was never uploaded")
+	}
+	if fake.uploads != 1 {
+		t.Errorf("uploaded %d certificates, want only the deployed one", fake.uploads)
+	}
+
+	// A later Deploy of the same version reuses the uploaded certificate
+	if err := deployer.Deploy(domain, version); err != nil {
+		t.Fatalf("second Deploy: %v", err)
+	}
+	if fake.uploads != 1 {
+		t.Errorf("second Deploy uploaded the version again")
+	}
+}
+
+func TestBaishanVerifyDetectsOtherCertificate(t *testing.T) {
+	fake := &fakeBaishan{
+		domains: []string{"www.example.com"},
+		https:   map[string]map[string]interface{}{"www.example.com": {}},
+	}
+	deployer := newTestBaishan(t, fake)
+
+	certs := certstore.New(t.TempDir(), 5)
+	domain := utils.Domain{DomainName: "www.example.com"}
+	version := testVersion(t, certs, domain.DomainName, time.Now().Add(90*24*time.Hour), domain.DomainName)
+
+	if err := deployer.Deploy(domain, version); err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	fake.https["www.example.com"]["cert_id"] = 7
+	if err := deployer.Verify(domain, version); err == nil {
+		t.Fatal("Verify succeeded although another certificate is bound")
+	}
+}
+
+func TestBaishanCertId(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{nil, ""},
+		{json.Number("1234567"), "1234567"},
+		{" 1234567 ", "1234567"},
+	}
+	for _, test := range tests {
+		if got := baishanCertId(test.value); got != test.want {
+			t.Errorf("baishanCertId(%#v) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package deploy
+
+import (
+	"AutoCert/src/application/certstore"
+	"AutoCert/src/utils"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+// Deployer installs certificates from the certificate store on a platform
+type Deployer interface {
+	// Deploy installs the certificate version for the domain
+	Deploy(domain utils.Domain, version *certstore.Version) error
+	// Verify checks that the platform now uses the certificate version
+	Verify(domain utils.Domain, version *certstore.Version) error
+	// Rollback reinstalls an earlier certificate version after a failed deployment
+	// or for the rollback command
+	Rollback(domain utils.Domain, previous *certstore.Version) error
+}
+
+// DeployerFactory creates a deployer for the given configuration
+type DeployerFactory func(config utils.Config) (Deployer, error)
+
+var (
+	deployerFactories = map[string]DeployerFactory{}
+	deployerLock      sync.RWMutex
+)
+
+// Register makes a deployer available under the given deploy_platform name
+func Register(platform string, factory DeployerFactory) {
+	deployerLock.Lock()
+	defer deployerLock.Unlock()
+
+	if _, exists := deployerFactories[platform]; exists {
+		panic(fmt.Sprintf("deployer %q registered twice", platform))
+	}
+	deployerFactories[platform] = factory
+}
+
+// Get returns the deployer registered for the given deploy_platform
+func Get(config utils.Config, platform string) (Deployer, error) {
+	deployerLock.RLock()
+	factory, ok := deployerFactories[platform]
+	deployerLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unsupported deploy platform %q (available: %v)", platform, Registered())
+	}
+	return factory(config)
+}
+
+// Registered returns the sorted names of all registered deploy platforms
+func Registered() []string {
+	deployerLock.RLock()
+	defer deployerLock.RUnlock()
+
+	names := make([]string, 0, len(deployerFactories))
+	for name := range deployerFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DeployDomain deploys the current certificate of the domain to each of its
+// deploy platforms, then checks once that the endpoints of the domain serve
+// it. When a deployment or a verification fails, every platform deployed so
+// far is rolled back to the previous version, which becomes the current
+// version again.
+func DeployDomain(config utils.Config, certs *certstore.Store, domain utils.Domain) error {
+	platforms := domain.DeployPlatforms()
+	if len(platforms) == 0 {
+		log.Printf("[INFO] No deploy platform configured for domain %s", domain.DomainName)
+		return nil
+	}
+
+	current, err := certs.Current(domain.DomainName)
+	if err != nil {
+		return err
+	}
+
+	for idx, platform := range platforms {
+		err := deployTo(config, platform, domain, current)
+		if err == nil {
+			log.Printf("[INFO] Deployed certificate version %s of domain %s to %s", current.Meta.Version, domain.DomainName, platform)
+			continue
+		}
+		log.Printf("[ERROR] Deployment of domain %s to %s failed: %v", domain.DomainName, platform, err)
+		return rollbackAfter(config, certs, domain, platforms[:idx+1], current, platform, err)
+	}
+
+	// Endpoints may be served by any of the platforms, e.g. a CDN edge and its
+	// origin, so they are only checked once every platform has the certificate
+	if err := verifyServed(config, domain, current); err != nil {
+		log.Printf("[ERROR] Verification of domain %s failed: %v", domain.DomainName, err)
+		return rollbackAfter(config, certs, domain, platforms, current, "the served certificate check", err)
+	}
+	return nil
+}
+
+// rollbackAfter rolls the platforms back to the previous version after the
+// deployment of current failed at stage and returns the resulting error
+func rollbackAfter(config utils.Config, certs *certstore.Store, domain utils.Domain, platforms []string, current *certstore.Version, stage string, err error) error {
+	previous := previousVersion(config, certs, domain, current)
+	if previous == nil {
+		log.Printf("[WARN] No previous certificate version of domain %s that is still valid beyond renew_before, not rolling back", domain.DomainName)
+		return fmt.Errorf("deployment of domain %s failed on %s: %v", domain.DomainName, stage, err)
+	}
+
+	log.Printf("[WARN] Rolling back domain %s to version %s", domain.DomainName, previous.Meta.Version)
+	if rollbackErr := rollbackTo(config, certs, domain, platforms, previous); rollbackErr != nil {
+		return fmt.Errorf("deployment of domain %s failed on %s: %v (%v)", domain.DomainName, stage, err, rollbackErr)
+	}
+	return fmt.Errorf("deployment of domain %s failed on %s and was rolled back to version %s: %v", domain.DomainName, stage, previous.Meta.Version, err)
+}
+
+// RollbackDomain makes a stored version current again and reinstalls it on
+// all deploy platforms of the domain. An empty version selects the version
+// stored before the current one.
+func RollbackDomain(config utils.Config, certs *certstore.Store, domain utils.Domain, version string) error {
+	current, err := certs.Current(domain.DomainName)
+	if err != nil {
+		return err
+	}
+
+	var target *certstore.Version
+	if version == "" {
+		target = previousVersion(config, certs, domain, current)
+		if target == nil {
+			return fmt.Errorf("no certificate version of domain %s before %s that is still valid beyond renew_before", domain.DomainName, current.Meta.Version)
+		}
+	} else {
+		target, err = certs.Get(domain.DomainName, version)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("[INFO] Rolling back domain %s from version %s to %s", domain.DomainName, current.Meta.Version, target.Meta.Version)
+	return rollbackTo(config, certs, domain, domain.DeployPlatforms(), target)
+}
+
+// deployTo runs Deploy and Verify on a single platform
+func deployTo(config utils.Config, platform string, domain utils.Domain, version *certstore.Version) error {
+	deployer, err := Get(config, platform)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Deploying certificate version %s of domain %s to %s", version.Meta.Version, domain.DomainName, platform)
+	if err := deployer.Deploy(domain, version); err != nil {
+		return err
+	}
+	if err := deployer.Verify(domain, version); err != nil {
+		return fmt.Errorf("verification failed: %v", err)
+	}
+	return nil
+}
+
+// rollbackTo points "current" at the target version, reinstalls it on the
+// platforms and checks once that the endpoints serve it again
+func rollbackTo(config utils.Config, certs *certstore.Store, domain utils.Domain, platforms []string, target *certstore.Version) error {
+	if err := certs.SetCurrent(domain.DomainName, target.Meta.Version); err != nil {
+		return fmt.Errorf("rollback failed: %v", err)
+	}
+
+	failed := []string{}
+	for _, platform := range platforms {
+		deployer, err := Get(config, platform)
+		if err == nil {
+			err = deployer.Rollback(domain, target)
+		}
+		if err == nil {
+			err = deployer.Verify(domain, target)
+		}
+		if err != nil {
+			log.Printf("[ERROR] Rollback of domain %s on %s failed: %v", domain.DomainName, platform, err)
+			failed = append(failed, platform)
+			continue
+		}
+		log.Printf("[INFO] Rolled back domain %s on %s to version %s", domain.DomainName, platform, target.Meta.Version)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("rollback of domain %s failed on %v", domain.DomainName, failed)
+	}
+	if err := verifyServed(config, domain, target); err != nil {
+		return fmt.Errorf("rollback of domain %s is not served: %v", domain.DomainName, err)
+	}
+	return nil
+}
+
+// previousVersion returns the newest version stored before current that is
+// neither expired nor within renew_before, so rolling back to it does not
+// trigger another renewal. It returns nil if there is none.
+func previousVersion(config utils.Config, certs *certstore.Store, domain utils.Domain, current *certstore.Version) *certstore.Version {
+	versions, err := certs.Versions(domain.DomainName)
+	if err != nil {
+		log.Printf("[WARN] Failed to list certificate versions of domain %s: %v", domain.DomainName, err)
+		return nil
+	}
+
+	renewBefore := config.RenewBefore(domain)
+	found := false
+	for _, version := range versions {
+		if version.Meta.Version == current.Meta.Version {
+			found = true
+			continue
+		}
+		if !found {
+			continue
+		}
+		if renewBefore.Reached(version.Meta.NotBefore, version.Meta.NotAfter) {
+			log.Printf("[INFO] Skipping certificate version %s of domain %s, it expires %s", version.Meta.Version, domain.DomainName, version.Meta.NotAfter.Format("2006-01-02 15:04:05"))
+			continue
+		}
+		return version
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package deploy
+
+import (
+	"AutoCert/src/application/certstore"
+	"AutoCert/src/utils"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testVersion stores a self-signed certificate for names, valid until notAfter
+func testVersion(t *testing.T, certs *certstore.Store, domain string, notAfter time.Time, names ...string) *certstore.Version {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	version, err := certs.Save(domain, certPEM, nil, keyPEM, certstore.Meta{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+func TestPreviousVersionSkipsExpiring(t *testing.T) {
+	certs := certstore.New(t.TempDir(), 10)
+	domain := utils.Domain{DomainName: "www.example.com"}
+	now := time.Now()
+
+	valid := testVersion(t, certs, domain.DomainName, now.Add(60*24*time.Hour), domain.DomainName)
+	testVersion(t, certs, domain.DomainName, now.Add(-time.Hour), domain.DomainName)
+	testVersion(t, certs, domain.DomainName, now.Add(24*time.Hour), domain.DomainName)
+	current := testVersion(t, certs, domain.DomainName, now.Add(90*24*time.Hour), domain.DomainName)
+
+	var config utils.Config
+	previous := previousVersion(config, certs, domain, current)
+	if previous == nil || previous.Meta.Version != valid.Meta.Version {
+		t.Fatalf("previousVersion = %v, want %s", previous, valid.Meta.Version)
+	}
+
+	// With a renew_before longer than the remaining lifetime nothing qualifies
+	config.Renewal.RenewBefore = utils.Threshold{Duration: 90 * 24 * time.Hour}
+	if previous := previousVersion(config, certs, domain, current); previous != nil {
+		t.Fatalf("previousVersion = %s, want none", previous.Meta.Version)
+	}
+}
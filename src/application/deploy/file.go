@@ -0,0 +1,308 @@
+package deploy
+
+import (
+	"AutoCert/src/application/certstore"
+	"AutoCert/src/utils"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultFileMode    = 0644
+	defaultKeyFileMode = 0600
+
+	// fileCommandTimeout bounds the validate and reload commands
+	fileCommandTimeout = 2 * time.Minute
+)
+
+func init() {
+	Register("file", newFileDeployer)
+}
+
+// fileDeployer writes the certificate to local paths for servers such as
+// nginx, haproxy or caddy and reloads them. The files written are restored
+// when the validation command rejects the new certificate.
+type fileDeployer struct{}
+
+// deployFile is a file written by the file and ssh deployers
+type deployFile struct {
+	path string
+	data []byte
+	mode os.FileMode
+}
+
+// fileBackup is the previous content of a deployed file, existed is false
+// when the file was created by the deployment
+type fileBackup struct {
+	path    string
+	data    []byte
+	mode    os.FileMode
+	uid     int
+	gid     int
+	existed bool
+}
+
+func newFileDeployer(config utils.Config) (Deployer, error) {
+	return &fileDeployer{}, nil
+}
+
+// certificateFiles returns the configured files with the content of the version
+func certificateFiles(settings utils.CertificateFiles, domain string, version *certstore.Version) ([]deployFile, error) {
+	mode, err := parseFileMode(settings.Mode, defaultFileMode)
+	if err != nil {
+		return nil, err
+	}
+	keyMode, err := parseFileMode(settings.KeyMode, defaultKeyFileMode)
+	if err != nil {
+		return nil, err
+	}
+
+	material, err := version.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []deployFile
+	add := func(path string, data []byte, mode os.FileMode) {
+		if path != "" {
+			files = append(files, deployFile{path: path, data: data, mode: mode})
+		}
+	}
+	add(settings.CertPath, material.CertificatePEM, mode)
+	add(settings.ChainPath, material.ChainPEM, mode)
+	add(settings.FullChainPath, material.FullChainPEM, mode)
+	add(settings.KeyPath, material.PrivateKeyPEM, keyMode)
+	add(settings.CombinedPath, combinedPEM(material), keyMode)
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no file path configured for domain %s", domain)
+	}
+	return files, nil
+}
+
+// combinedPEM is the full chain followed by the private key, as haproxy expects
+func combinedPEM(material *certstore.Material) []byte {
+	combined := append([]byte{}, material.FullChainPEM...)
+	if len(combined) > 0 && combined[len(combined)-1] != '\n' {
+		combined = append(combined, '\n')
+	}
+	return append(combined, material.PrivateKeyPEM...)
+}
+
+// parseFileMode parses an octal mode such as "0640", empty selects the default
+func parseFileMode(mode string, defaultMode os.FileMode) (os.FileMode, error) {
+	if mode == "" {
+		return defaultMode, nil
+	}
+	parsed, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || parsed > 0777 {
+		return 0, fmt.Errorf("invalid file mode %q", mode)
+	}
+	return os.FileMode(parsed), nil
+}
+
+// parseOwner resolves "user" or "user:group" to numeric IDs, -1 leaves an ID unchanged
+func parseOwner(owner string) (int, int, error) {
+	if owner == "" {
+		return -1, -1, nil
+	}
+
+	userName, groupName, _ := strings.Cut(owner, ":")
+	uid, gid := -1, -1
+
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			return 0, 0, fmt.Errorf("unknown user %q: %v", userName, err)
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return 0, 0, fmt.Errorf("unsupported uid %q of user %s", u.Uid, userName)
+		}
+	}
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			return 0, 0, fmt.Errorf("unknown group %q: %v", groupName, err)
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return 0, 0, fmt.Errorf("unsupported gid %q of group %s", g.Gid, groupName)
+		}
+	}
+	return uid, gid, nil
+}
+
+// writeFileAtomic replaces path by renaming a fully written temporary file over it
+func writeFileAtomic(path string, data []byte, mode os.FileMode, uid, gid int) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %v", dir, err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for %s: %v", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync %s: %v", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return fmt.Errorf("failed to set mode of %s: %v", path, err)
+	}
+	if uid != -1 || gid != -1 {
+		if err := os.Chown(tmp.Name(), uid, gid); err != nil {
+			return fmt.Errorf("failed to set owner of %s: %v", path, err)
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s: %v", path, err)
+	}
+	return nil
+}
+
+// backup reads the current content of the files so they can be restored
+func backup(files []deployFile) ([]fileBackup, error) {
+	backups := make([]fileBackup, 0, len(files))
+	for _, file := range files {
+		info, err := os.Stat(file.path)
+		if os.IsNotExist(err) {
+			backups = append(backups, fileBackup{path: file.path})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %s: %v", file.path, err)
+		}
+
+		data, err := os.ReadFile(file.path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to back up %s: %v", file.path, err)
+		}
+		// The original owner is restored, not the configured one
+		uid, gid := fileOwner(info)
+		backups = append(backups, fileBackup{path: file.path, data: data, mode: info.Mode().Perm(), uid: uid, gid: gid, existed: true})
+	}
+	return backups, nil
+}
+
+// restore puts back the backed up files with their original mode and owner
+// and removes the ones that did not exist
+func restore(backups []fileBackup) error {
+	failed := []string{}
+	for _, backup := range backups {
+		var err error
+		if backup.existed {
+			err = writeFileAtomic(backup.path, backup.data, backup.mode, backup.uid, backup.gid)
+		} else if removeErr := os.Remove(backup.path); removeErr != nil && !os.IsNotExist(removeErr) {
+			err = removeErr
+		}
+		if err != nil {
+			log.Printf("[ERROR] Failed to restore %s: %v", backup.path, err)
+			failed = append(failed, backup.path)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to restore %v", failed)
+	}
+	return nil
+}
+
+// runCommand runs a shell command and includes its output in the error
+func runCommand(name, command string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), fileCommandTimeout)
+	defer cancel()
+
+	log.Printf("[INFO] Running %s command: %s", name, command)
+	output, err := exec.CommandContext(ctx, "sh", "-c", command).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s command %q failed: %v: %s", name, command, err, strings.TrimSpace(string(output)))
+	}
+	if len(output) > 0 {
+		log.Printf("[INFO] %s command output: %s", name, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (d *fileDeployer) Deploy(domain utils.Domain, version *certstore.Version) error {
+	files, err := certificateFiles(domain.File.CertificateFiles, domain.DomainName, version)
+	if err != nil {
+		return err
+	}
+	uid, gid, err := parseOwner(domain.File.Owner)
+	if err != nil {
+		return err
+	}
+
+	backups, err := backup(files)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := writeFileAtomic(file.path, file.data, file.mode, uid, gid); err != nil {
+			if restoreErr := restore(backups); restoreErr != nil {
+				return fmt.Errorf("%v (%v)", err, restoreErr)
+			}
+			return err
+		}
+		log.Printf("[INFO] Wrote %s for domain %s", file.path, domain.DomainName)
+	}
+
+	if domain.File.ValidateCommand != "" {
+		if err := runCommand("validate", domain.File.ValidateCommand); err != nil {
+			log.Printf("[WARN] Restoring the previous files of domain %s", domain.DomainName)
+			if restoreErr := restore(backups); restoreErr != nil {
+				return fmt.Errorf("%v (%v)", err, restoreErr)
+			}
+			return err
+		}
+	}
+
+	if domain.File.ReloadCommand != "" {
+		return runCommand("reload", domain.File.ReloadCommand)
+	}
+	return nil
+}
+
+// Verify checks that the files on disk hold the certificate of the version
+func (d *fileDeployer) Verify(domain utils.Domain, version *certstore.Version) error {
+	files, err := certificateFiles(domain.File.CertificateFiles, domain.DomainName, version)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file.path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", file.path, err)
+		}
+		if !bytes.Equal(data, file.data) {
+			return fmt.Errorf("%s does not hold certificate version %s", file.path, version.Meta.Version)
+		}
+	}
+	return nil
+}
+
+func (d *fileDeployer) Rollback(domain utils.Domain, previous *certstore.Version) error {
+	return d.Deploy(domain, previous)
+}
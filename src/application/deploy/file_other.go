@@ -0,0 +1,11 @@
+//go:build !unix
+
+package deploy
+
+import "os"
+
+// fileOwner returns -1 for the uid and gid, files have no numeric owner to
+// restore on this platform
+func fileOwner(info os.FileInfo) (int, int) {
+	return -1, -1
+}
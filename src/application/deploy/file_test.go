@@ -0,0 +1,61 @@
+package deploy
+
+import (
+	"AutoCert/src/application/certstore"
+	"AutoCert/src/utils"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testFileDomain returns a domain writing fullchain and key into dir
+func testFileDomain(dir string) utils.Domain {
+	domain := utils.Domain{DomainName: "www.example.com"}
+	domain.File.FullChainPath = filepath.Join(dir, "www.example.com.crt")
+	domain.File.KeyPath = filepath.Join(dir, "www.example.com.key")
+	return domain
+}
+
+func TestFileDeployRestoresOnFailedValidation(t *testing.T) {
+	dir := t.TempDir()
+	domain := testFileDomain(dir)
+	domain.File.ValidateCommand = "false"
+
+	previous := []byte("previous certificate\n")
+	if err := os.WriteFile(domain.File.FullChainPath, previous, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	certs := certstore.New(filepath.Join(dir, "certs"), 5)
+	version := testVersion(t, certs, domain.DomainName, time.Now().Add(90*24*time.Hour), domain.DomainName)
+
+	deployer := &fileDeployer{}
+	if err := deployer.Deploy(domain, version); err == nil {
+		t.Fatal("Deploy succeeded although the validate command failed")
+	}
+
+	data, err := os.ReadFile(domain.File.FullChainPath)
+	if err != nil || !bytes.Equal(data, previous) {
+		t.Fatalf("previous certificate was not restored: %v", err)
+	}
+	info, err := os.Stat(domain.File.FullChainPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("restored file has mode %v, want 0640", info.Mode().Perm())
+	}
+	if _, err := os.Stat(domain.File.KeyPath); !os.IsNotExist(err) {
+		t.Errorf("key file created by the failed deployment was not removed")
+	}
+
+	domain.File.ValidateCommand = "true"
+	if err := deployer.Deploy(domain, version); err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if err := deployer.Verify(domain, version); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package deploy
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the uid and gid of the file, -1 when they are unknown
+func fileOwner(info os.FileInfo) (int, int) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(stat.Uid), int(stat.Gid)
+	}
+	return -1, -1
+}
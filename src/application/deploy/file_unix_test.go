@@ -0,0 +1,59 @@
+//go:build unix
+
+package deploy
+
+import (
+	"AutoCert/src/application/certstore"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFileDeployRestoresOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing file owners requires root")
+	}
+
+	dir := t.TempDir()
+	domain := testFileDomain(dir)
+	domain.File.Owner = "root"
+	domain.File.ValidateCommand = "false"
+
+	// The existing certificate belongs to another user than the configured owner
+	if err := os.WriteFile(domain.File.FullChainPath, []byte("previous certificate\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(domain.File.FullChainPath, 1234, 5678); err != nil {
+		t.Fatal(err)
+	}
+
+	certs := certstore.New(filepath.Join(dir, "certs"), 5)
+	version := testVersion(t, certs, domain.DomainName, time.Now().Add(90*24*time.Hour), domain.DomainName)
+
+	deployer := &fileDeployer{}
+	if err := deployer.Deploy(domain, version); err == nil {
+		t.Fatal("Deploy succeeded although the validate command failed")
+	}
+	info, err := os.Stat(domain.File.FullChainPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat := info.Sys().(*syscall.Stat_t); stat.Uid != 1234 || stat.Gid != 5678 {
+		t.Errorf("restored file has owner %d:%d, want 1234:5678", stat.Uid, stat.Gid)
+	}
+
+	// Once validation passes the configured owner applies
+	domain.File.ValidateCommand = "true"
+	if err := deployer.Deploy(domain, version); err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	info, err = os.Stat(domain.File.FullChainPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat := info.Sys().(*syscall.Stat_t); stat.Uid != 0 {
+		t.Errorf("deployed file has uid %d, want the configured owner root", stat.Uid)
+	}
+}
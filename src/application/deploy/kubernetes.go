@@ -0,0 +1,261 @@
+package deploy
+
+import (
+	"AutoCert/src/application/certstore"
+	"AutoCert/src/utils"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	defaultKubernetesNamespace = "default"
+	kubernetesTimeout          = 30 * time.Second
+
+	// restartedAtAnnotation is the pod template annotation kubectl rollout restart sets
+	restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+	versionAnnotation     = "autocert/version"
+	fingerprintAnnotation = "autocert/fingerprint-sha256"
+	// updatedAtAnnotation records when the Secret data was last written, Deployments restarted before it are restarted again
+	updatedAtAnnotation = "autocert/updated-at"
+)
+
+func init() {
+	Register("kubernetes", newKubernetesDeployer)
+}
+
+// kubernetesDeployer writes the certificate to a kubernetes.io/tls Secret and
+// optionally restarts the Deployments that mount it
+type kubernetesDeployer struct {
+	// newClient returns the clientset for the domain, replaced by a fake clientset in tests
+	newClient func(domain utils.Domain) (kubernetes.Interface, error)
+}
+
+func newKubernetesDeployer(config utils.Config) (Deployer, error) {
+	return &kubernetesDeployer{newClient: newKubernetesClient}, nil
+}
+
+// newKubernetesClient uses the in-cluster service account unless a kubeconfig
+// or context is configured, falling back to the default kubeconfig outside a cluster
+func newKubernetesClient(domain utils.Domain) (kubernetes.Interface, error) {
+	settings := domain.Kubernetes
+
+	var restConfig *rest.Config
+	if settings.Kubeconfig == "" && settings.Context == "" {
+		config, err := rest.InClusterConfig()
+		if err != nil && !errors.Is(err, rest.ErrNotInCluster) {
+			return nil, fmt.Errorf("failed to load in-cluster config: %v", err)
+		}
+		restConfig = config
+	}
+
+	if restConfig == nil {
+		rules := clientcmd.NewDefaultClientConfigLoadingRules()
+		rules.ExplicitPath = settings.Kubeconfig
+		overrides := &clientcmd.ConfigOverrides{CurrentContext: settings.Context}
+
+		config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load kubeconfig: %v", err)
+		}
+		restConfig = config
+	}
+
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %v", err)
+	}
+	return client, nil
+}
+
+// secretLocation returns the configured namespace and Secret name, the name
+// defaults to the domain such as "www-example-com-tls" or "wildcard-example-com-tls"
+func secretLocation(domain utils.Domain) (string, string) {
+	namespace := domain.Kubernetes.Namespace
+	if namespace == "" {
+		namespace = defaultKubernetesNamespace
+	}
+
+	name := domain.Kubernetes.SecretName
+	if name == "" {
+		name = strings.ReplaceAll(strings.Replace(domain.DomainName, "*", "wildcard", 1), ".", "-") + "-tls"
+	}
+	return namespace, name
+}
+
+func (d *kubernetesDeployer) Deploy(domain utils.Domain, version *certstore.Version) error {
+	client, err := d.newClient(domain)
+	if err != nil {
+		return err
+	}
+	material, err := version.Load()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), kubernetesTimeout)
+	defer cancel()
+
+	namespace, name := secretLocation(domain)
+	data := map[string][]byte{
+		corev1.TLSCertKey:       material.FullChainPEM,
+		corev1.TLSPrivateKeyKey: material.PrivateKeyPEM,
+	}
+	updatedAt := time.Now().Truncate(time.Second)
+	annotations := map[string]string{
+		versionAnnotation:     version.Meta.Version,
+		fingerprintAnnotation: version.Meta.Fingerprint,
+		updatedAtAnnotation:   updatedAt.Format(time.RFC3339),
+	}
+
+	secrets := client.CoreV1().Secrets(namespace)
+	secret, err := secrets.Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   namespace,
+				Labels:      map[string]string{"app.kubernetes.io/managed-by": "autocert"},
+				Annotations: annotations,
+			},
+			Type: corev1.SecretTypeTLS,
+			Data: data,
+		}
+		if _, err := secrets.Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create Secret %s/%s: %v", namespace, name, err)
+		}
+		log.Printf("[INFO] Created Secret %s/%s for domain %s", namespace, name, domain.DomainName)
+	case err != nil:
+		return fmt.Errorf("failed to get Secret %s/%s: %v", namespace, name, err)
+	default:
+		if secret.Type != corev1.SecretTypeTLS {
+			return fmt.Errorf("Secret %s/%s has type %s instead of %s", namespace, name, secret.Type, corev1.SecretTypeTLS)
+		}
+		if bytes.Equal(secret.Data[corev1.TLSCertKey], data[corev1.TLSCertKey]) &&
+			bytes.Equal(secret.Data[corev1.TLSPrivateKeyKey], data[corev1.TLSPrivateKeyKey]) {
+			// A restart that failed after the Secret was written is still due
+			log.Printf("[INFO] Secret %s/%s already holds certificate version %s", namespace, name, version.Meta.Version)
+			updatedAt, _ = time.Parse(time.RFC3339, secret.Annotations[updatedAtAnnotation])
+			return d.restartDeployments(ctx, client, namespace, domain.Kubernetes.RestartDeployments, updatedAt)
+		}
+
+		if secret.Annotations == nil {
+			secret.Annotations = map[string]string{}
+		}
+		for key, value := range annotations {
+			secret.Annotations[key] = value
+		}
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		for key, value := range data {
+			secret.Data[key] = value
+		}
+		if _, err := secrets.Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to update Secret %s/%s: %v", namespace, name, err)
+		}
+		log.Printf("[INFO] Updated Secret %s/%s for domain %s", namespace, name, domain.DomainName)
+	}
+
+	return d.restartDeployments(ctx, client, namespace, domain.Kubernetes.RestartDeployments, updatedAt)
+}
+
+// restartDeployments triggers a rollout of each Deployment the way kubectl
+// rollout restart does, unless it was already restarted at or after since
+func (d *kubernetesDeployer) restartDeployments(ctx context.Context, client kubernetes.Interface, namespace string, names []string, since time.Time) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{
+						restartedAtAnnotation: time.Now().Format(time.RFC3339),
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode restart patch: %v", err)
+	}
+
+	failed := []string{}
+	deployments := client.AppsV1().Deployments(namespace)
+	for _, name := range names {
+		deployment, err := deployments.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			log.Printf("[ERROR] Failed to get Deployment %s/%s: %v", namespace, name, err)
+			failed = append(failed, name)
+			continue
+		}
+		restartedAt, err := time.Parse(time.RFC3339, deployment.Spec.Template.Annotations[restartedAtAnnotation])
+		if err == nil && !restartedAt.Before(since) {
+			log.Printf("[INFO] Deployment %s/%s was already restarted after the Secret changed", namespace, name)
+			continue
+		}
+
+		_, err = deployments.Patch(ctx, name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+		if err != nil {
+			log.Printf("[ERROR] Failed to restart Deployment %s/%s: %v", namespace, name, err)
+			failed = append(failed, name)
+			continue
+		}
+		log.Printf("[INFO] Restarted Deployment %s/%s", namespace, name)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to restart Deployments %v in namespace %s", failed, namespace)
+	}
+	return nil
+}
+
+// Verify checks that the Secret holds the certificate of the version
+func (d *kubernetesDeployer) Verify(domain utils.Domain, version *certstore.Version) error {
+	client, err := d.newClient(domain)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), kubernetesTimeout)
+	defer cancel()
+
+	namespace, name := secretLocation(domain)
+	secret, err := client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get Secret %s/%s: %v", namespace, name, err)
+	}
+
+	block, _ := pem.Decode(secret.Data[corev1.TLSCertKey])
+	if block == nil {
+		return fmt.Errorf("Secret %s/%s has no certificate", namespace, name)
+	}
+	sum := sha256.Sum256(block.Bytes)
+	if fingerprint := hex.EncodeToString(sum[:]); fingerprint != version.Meta.Fingerprint {
+		return fmt.Errorf("Secret %s/%s holds certificate %s instead of version %s", namespace, name, fingerprint, version.Meta.Version)
+	}
+	return nil
+}
+
+func (d *kubernetesDeployer) Rollback(domain utils.Domain, previous *certstore.Version) error {
+	return d.Deploy(domain, previous)
+}
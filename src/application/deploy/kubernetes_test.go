@@ -0,0 +1,149 @@
+package deploy
+
+import (
+	"AutoCert/src/application/certstore"
+	"AutoCert/src/utils"
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestKubernetes(objects ...runtime.Object) (*kubernetesDeployer, *fake.Clientset) {
+	client := fake.NewSimpleClientset(objects...)
+	deployer := &kubernetesDeployer{
+		newClient: func(domain utils.Domain) (kubernetes.Interface, error) {
+			return client, nil
+		},
+	}
+	return deployer, client
+}
+
+func testDeployment(name, restartedAt string) *appsv1.Deployment {
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "apps"}}
+	if restartedAt != "" {
+		deployment.Spec.Template.Annotations = map[string]string{restartedAtAnnotation: restartedAt}
+	}
+	return deployment
+}
+
+// restartedDeployments returns the Deployments patched through the client
+func restartedDeployments(client *fake.Clientset) []string {
+	var names []string
+	for _, action := range client.Actions() {
+		if patch, ok := action.(interface{ GetName() string }); ok && action.GetVerb() == "patch" && action.GetResource().Resource == "deployments" {
+			names = append(names, patch.GetName())
+		}
+	}
+	return names
+}
+
+func testKubernetesDomain() utils.Domain {
+	domain := utils.Domain{DomainName: "app.example.com"}
+	domain.Kubernetes.Namespace = "apps"
+	domain.Kubernetes.RestartDeployments = []string{"app"}
+	return domain
+}
+
+func TestKubernetesDeployCreatesSecret(t *testing.T) {
+	deployer, client := newTestKubernetes(testDeployment("app", ""))
+	domain := testKubernetesDomain()
+
+	certs := certstore.New(t.TempDir(), 5)
+	version := testVersion(t, certs, domain.DomainName, time.Now().Add(90*24*time.Hour), domain.DomainName)
+
+	if err := deployer.Deploy(domain, version); err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if err := deployer.Verify(domain, version); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	secret, err := client.CoreV1().Secrets("apps").Get(context.Background(), "app-example-com-tls", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Type != corev1.SecretTypeTLS || secret.Annotations[versionAnnotation] != version.Meta.Version {
+		t.Errorf("Secret has type %s and annotations %v", secret.Type, secret.Annotations)
+	}
+
+	deployment, err := client.AppsV1().Deployments("apps").Get(context.Background(), "app", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deployment.Spec.Template.Annotations[restartedAtAnnotation] == "" {
+		t.Error("Deployment was not restarted")
+	}
+
+	other := testVersion(t, certs, domain.DomainName, time.Now().Add(80*24*time.Hour), domain.DomainName)
+	if err := deployer.Verify(domain, other); err == nil {
+		t.Error("Verify succeeded for a version the Secret does not hold")
+	}
+}
+
+// A run that wrote the Secret but failed to restart the Deployments leaves
+// the restart to the next deployment of the same version
+func TestKubernetesDeployRestartsWhenSecretUnchanged(t *testing.T) {
+	certs := certstore.New(t.TempDir(), 5)
+	domain := testKubernetesDomain()
+	domain.Kubernetes.RestartDeployments = []string{"app", "worker", "api"}
+	version := testVersion(t, certs, domain.DomainName, time.Now().Add(90*24*time.Hour), domain.DomainName)
+	material, err := version.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updatedAt := time.Now().Add(-time.Hour).UTC()
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app-example-com-tls",
+			Namespace:   "apps",
+			Annotations: map[string]string{updatedAtAnnotation: updatedAt.Format(time.RFC3339)},
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       material.FullChainPEM,
+			corev1.TLSPrivateKeyKey: material.PrivateKeyPEM,
+		},
+	}
+	deployer, client := newTestKubernetes(secret,
+		testDeployment("app", ""),
+		testDeployment("worker", updatedAt.Add(-time.Minute).Format(time.RFC3339)),
+		testDeployment("api", updatedAt.Add(time.Minute).Format(time.RFC3339)),
+	)
+
+	if err := deployer.Deploy(domain, version); err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+
+	restarted := restartedDeployments(client)
+	if len(restarted) != 2 || restarted[0] != "app" || restarted[1] != "worker" {
+		t.Errorf("restarted %v, want [app worker]", restarted)
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "update" {
+			t.Errorf("unchanged Secret was updated")
+		}
+	}
+}
+
+func TestKubernetesDeployRejectsOtherSecretType(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-example-com-tls", Namespace: "apps"},
+		Type:       corev1.SecretTypeOpaque,
+	}
+	deployer, _ := newTestKubernetes(secret)
+	domain := testKubernetesDomain()
+
+	certs := certstore.New(t.TempDir(), 5)
+	version := testVersion(t, certs, domain.DomainName, time.Now().Add(90*24*time.Hour), domain.DomainName)
+	if err := deployer.Deploy(domain, version); err == nil {
+		t.Fatal("Deploy overwrote an Opaque Secret")
+	}
+}
@@ -0,0 +1,42 @@
+package deploy
+
+import (
+	"AutoCert/src/application/certstore"
+	"fmt"
+	"strings"
+)
+
+// certificateName names the uploaded certificate after the domain and version
+func certificateName(domain string, version *certstore.Version) string {
+	return fmt.Sprintf("autocert-%s-%s", strings.ReplaceAll(domain, "*", "_"), version.Meta.Version)
+}
+
+// coversDomain reports whether the certificate names include domain, directly or by wildcard
+func coversDomain(dnsNames []string, domain string) bool {
+	for _, name := range dnsNames {
+		if strings.EqualFold(name, domain) {
+			return true
+		}
+		if strings.HasPrefix(name, "*.") {
+			if idx := strings.Index(domain, "."); idx > 0 && strings.EqualFold(name[2:], domain[idx+1:]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// coversAll reports whether the certificate names include every one of names,
+// so a certificate with the names can be replaced by one with dnsNames
+// without leaving any of its hosts uncovered
+func coversAll(dnsNames []string, names []string) bool {
+	if len(names) == 0 {
+		return false
+	}
+	for _, name := range names {
+		if !coversDomain(dnsNames, name) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,244 @@
+package deploy
+
+import (
+	"AutoCert/src/application/certstore"
+	"AutoCert/src/utils"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	defaultSSHPort = 22
+	sshDialTimeout = 30 * time.Second
+)
+
+func init() {
+	Register("ssh", newSSHDeployer)
+}
+
+// sshDeployer uploads the certificate to remote hosts over SFTP and runs the
+// configured pre and post commands there, e.g. to reload the web server
+type sshDeployer struct{}
+
+func newSSHDeployer(config utils.Config) (Deployer, error) {
+	return &sshDeployer{}, nil
+}
+
+// clientConfig builds the key based SSH client configuration of the domain
+func (d *sshDeployer) clientConfig(domain utils.Domain) (*ssh.ClientConfig, error) {
+	settings := domain.SSH
+	if settings.User == "" || settings.PrivateKey == "" {
+		return nil, fmt.Errorf("ssh user and private_key are not configured for domain %s", domain.DomainName)
+	}
+
+	keyPEM, err := os.ReadFile(settings.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH private key: %v", err)
+	}
+	var signer ssh.Signer
+	if settings.Passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(keyPEM, []byte(settings.Passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(keyPEM)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SSH private key: %v", err)
+	}
+
+	knownHostsPath := settings.KnownHosts
+	if knownHostsPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("known_hosts is not configured: %v", err)
+		}
+		knownHostsPath = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known hosts: %v", err)
+	}
+
+	return &ssh.ClientConfig{
+		User:            settings.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sshDialTimeout,
+	}, nil
+}
+
+// sshAddress adds the default SSH port to host when it has none
+func sshAddress(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, strconv.Itoa(defaultSSHPort))
+}
+
+// runRemote runs a command on the host and includes its output in the error
+func runRemote(client *ssh.Client, host, name, command string) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to open SSH session: %v", err)
+	}
+	defer session.Close()
+
+	log.Printf("[INFO] Running %s command on %s: %s", name, host, command)
+	output, err := session.CombinedOutput(command)
+	if err != nil {
+		return fmt.Errorf("%s command %q failed on %s: %v: %s", name, command, host, err, strings.TrimSpace(string(output)))
+	}
+	if len(output) > 0 {
+		log.Printf("[INFO] %s command output on %s: %s", name, host, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// upload writes the file through a temporary file renamed over the target
+func upload(client *sftp.Client, file deployFile) error {
+	dir := path.Dir(file.path)
+	if err := client.MkdirAll(dir); err != nil {
+		return fmt.Errorf("failed to create directory %s: %v", dir, err)
+	}
+
+	tmpPath := path.Join(dir, "."+path.Base(file.path)+".autocert-tmp")
+	tmp, err := client.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", tmpPath, err)
+	}
+	if _, err := tmp.Write(file.data); err != nil {
+		tmp.Close()
+		client.Remove(tmpPath)
+		return fmt.Errorf("failed to write %s: %v", tmpPath, err)
+	}
+	if err := tmp.Close(); err != nil {
+		client.Remove(tmpPath)
+		return fmt.Errorf("failed to write %s: %v", tmpPath, err)
+	}
+
+	if err := client.Chmod(tmpPath, file.mode); err != nil {
+		client.Remove(tmpPath)
+		return fmt.Errorf("failed to set mode of %s: %v", file.path, err)
+	}
+	if err := client.PosixRename(tmpPath, file.path); err != nil {
+		client.Remove(tmpPath)
+		return fmt.Errorf("failed to replace %s: %v", file.path, err)
+	}
+	return nil
+}
+
+// deployHost uploads the files to a single host between the pre and post commands
+func (d *sshDeployer) deployHost(domain utils.Domain, config *ssh.ClientConfig, host string, files []deployFile) error {
+	client, err := ssh.Dial("tcp", sshAddress(host), config)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	if domain.SSH.PreCommand != "" {
+		if err := runRemote(client, host, "pre", domain.SSH.PreCommand); err != nil {
+			return err
+		}
+	}
+
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		return fmt.Errorf("failed to start SFTP: %v", err)
+	}
+	defer sftpClient.Close()
+
+	for _, file := range files {
+		if err := upload(sftpClient, file); err != nil {
+			return err
+		}
+		log.Printf("[INFO] Uploaded %s to %s for domain %s", file.path, host, domain.DomainName)
+	}
+
+	if domain.SSH.PostCommand != "" {
+		return runRemote(client, host, "post", domain.SSH.PostCommand)
+	}
+	return nil
+}
+
+func (d *sshDeployer) Deploy(domain utils.Domain, version *certstore.Version) error {
+	if len(domain.SSH.Hosts) == 0 {
+		return fmt.Errorf("no ssh host configured for domain %s", domain.DomainName)
+	}
+
+	config, err := d.clientConfig(domain)
+	if err != nil {
+		return err
+	}
+	files, err := certificateFiles(domain.SSH.CertificateFiles, domain.DomainName, version)
+	if err != nil {
+		return err
+	}
+
+	failed := []string{}
+	for _, host := range domain.SSH.Hosts {
+		if err := d.deployHost(domain, config, host, files); err != nil {
+			log.Printf("[ERROR] Deployment of domain %s to %s failed: %v", domain.DomainName, host, err)
+			failed = append(failed, host)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("deployment failed on %d of %d hosts: %v", len(failed), len(domain.SSH.Hosts), failed)
+	}
+	return nil
+}
+
+// Verify connects to each host over TLS and checks that it serves the
+// certificate of the version for the server names of the check endpoints
+func (d *sshDeployer) Verify(domain utils.Domain, version *certstore.Version) error {
+	port := domain.SSH.VerifyPort
+	if port == 0 {
+		port = defaultVerifyPort
+	}
+
+	endpoints := verifyEndpoints(domain)
+	if len(endpoints) == 0 {
+		log.Printf("[WARN] No check endpoints configured for wildcard domain %s, skipping served certificate check on the SSH hosts", domain.DomainName)
+		return nil
+	}
+	serverNames := []string{}
+	for _, target := range endpoints {
+		if !slices.Contains(serverNames, target.ServerName) {
+			serverNames = append(serverNames, target.ServerName)
+		}
+	}
+
+	for _, host := range domain.SSH.Hosts {
+		hostname := host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			hostname = h
+		}
+		address := net.JoinHostPort(hostname, strconv.Itoa(port))
+
+		for _, serverName := range serverNames {
+			fingerprint, err := servedFingerprint(address, serverName)
+			if err != nil {
+				return fmt.Errorf("%s: %v", address, err)
+			}
+			if fingerprint != version.Meta.Fingerprint {
+				return fmt.Errorf("%s serves certificate %s for %s instead of version %s", address, fingerprint, serverName, version.Meta.Version)
+			}
+		}
+	}
+	return nil
+}
+
+func (d *sshDeployer) Rollback(domain utils.Domain, previous *certstore.Version) error {
+	return d.Deploy(domain, previous)
+}
@@ -0,0 +1,369 @@
+package deploy
+
+import (
+	"AutoCert/src/application/certstore"
+	"AutoCert/src/utils"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testSSHServer accepts the authorized key, serves SFTP on the local
+// filesystem and records exec commands, "false" exits with status 1
+type testSSHServer struct {
+	addr    string
+	hostKey ssh.Signer
+
+	mu       sync.Mutex
+	commands []string
+}
+
+func newTestSigner(t *testing.T) (ssh.Signer, ed25519.PrivateKey) {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, key
+}
+
+func startSSHServer(t *testing.T, authorized ssh.PublicKey) *testSSHServer {
+	t.Helper()
+
+	hostKey, _ := newTestSigner(t)
+	server := &testSSHServer{hostKey: hostKey}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "deploy" && bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	server.addr = listener.Addr().String()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serveConn(conn, config)
+		}
+	}()
+	return server
+}
+
+func (s *testSSHServer) serveConn(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go s.serveSession(channel, requests)
+	}
+}
+
+func (s *testSSHServer) serveSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	for request := range requests {
+		switch request.Type {
+		case "exec":
+			var payload struct{ Command string }
+			ssh.Unmarshal(request.Payload, &payload)
+			request.Reply(true, nil)
+
+			s.mu.Lock()
+			s.commands = append(s.commands, payload.Command)
+			s.mu.Unlock()
+
+			status := uint32(0)
+			if payload.Command == "false" {
+				channel.Stderr().Write([]byte("command failed\n"))
+				status = 1
+			}
+			channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+			return
+		case "subsystem":
+			var payload struct{ Name string }
+			ssh.Unmarshal(request.Payload, &payload)
+			if payload.Name != "sftp" {
+				request.Reply(false, nil)
+				continue
+			}
+			request.Reply(true, nil)
+
+			server, err := sftp.NewServer(channel)
+			if err != nil {
+				return
+			}
+			server.Serve()
+			return
+		default:
+			request.Reply(false, nil)
+		}
+	}
+}
+
+func (s *testSSHServer) executed() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.commands...)
+}
+
+// testTLSServer serves the certificate of a version and records the SNI of each handshake
+type testTLSServer struct {
+	port int
+
+	mu          sync.Mutex
+	serverNames []string
+}
+
+func (s *testTLSServer) requested() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.serverNames...)
+}
+
+// serveVersion serves the certificate of the version over TLS on a local port
+func serveVersion(t *testing.T, version *certstore.Version) *testTLSServer {
+	t.Helper()
+
+	material, err := version.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := tls.X509KeyPair(material.FullChainPEM, material.PrivateKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &testTLSServer{}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			server.mu.Lock()
+			server.serverNames = append(server.serverNames, hello.ServerName)
+			server.mu.Unlock()
+			return nil, nil
+		},
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	server.port = listener.Addr().(*net.TCPAddr).Port
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	return server
+}
+
+// testSSHDomain returns a domain deploying to the server with a client key
+// and known_hosts entry written to dir
+func testSSHDomain(t *testing.T, dir string) (utils.Domain, ssh.Signer) {
+	t.Helper()
+
+	signer, key := newTestSigner(t)
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	domain := utils.Domain{DomainName: "www.example.com"}
+	domain.SSH.User = "deploy"
+	domain.SSH.PrivateKey = keyPath
+	domain.SSH.KnownHosts = filepath.Join(dir, "known_hosts")
+	domain.SSH.FullChainPath = filepath.Join(dir, "remote", "ssl", "www.example.com.crt")
+	domain.SSH.KeyPath = filepath.Join(dir, "remote", "ssl", "www.example.com.key")
+	return domain, signer
+}
+
+func writeKnownHosts(t *testing.T, path, addr string, hostKey ssh.PublicKey) {
+	t.Helper()
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostKey)
+	if err := os.WriteFile(path, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSSHDeployAndVerify(t *testing.T) {
+	dir := t.TempDir()
+	domain, signer := testSSHDomain(t, dir)
+	server := startSSHServer(t, signer.PublicKey())
+	writeKnownHosts(t, domain.SSH.KnownHosts, server.addr, server.hostKey.PublicKey())
+
+	domain.SSH.Hosts = []string{server.addr}
+	domain.SSH.PreCommand = "nginx -v"
+	domain.SSH.PostCommand = "nginx -t && systemctl reload nginx"
+
+	certs := certstore.New(filepath.Join(dir, "certs"), 5)
+	version := testVersion(t, certs, domain.DomainName, time.Now().Add(90*24*time.Hour), domain.DomainName)
+	material, err := version.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deployer := &sshDeployer{}
+	if err := deployer.Deploy(domain, version); err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+
+	fullChain, err := os.ReadFile(domain.SSH.FullChainPath)
+	if err != nil || !bytes.Equal(fullChain, material.FullChainPEM) {
+		t.Errorf("uploaded fullchain differs from the version: %v", err)
+	}
+	info, err := os.Stat(domain.SSH.KeyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != defaultKeyFileMode {
+		t.Errorf("key file mode is %v, want %v", info.Mode().Perm(), defaultKeyFileMode)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, "remote", "ssl", ".*")); len(leftovers) > 0 {
+		t.Errorf("temporary files left behind: %v", leftovers)
+	}
+
+	want := []string{domain.SSH.PreCommand, domain.SSH.PostCommand}
+	if got := server.executed(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("executed %q, want %q", got, want)
+	}
+
+	served := serveVersion(t, version)
+	domain.SSH.VerifyPort = served.port
+	if err := deployer.Verify(domain, version); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if got := served.requested(); len(got) != 1 || got[0] != domain.DomainName {
+		t.Errorf("Verify asked for %q, want %s", got, domain.DomainName)
+	}
+
+	other := testVersion(t, certs, domain.DomainName, time.Now().Add(80*24*time.Hour), domain.DomainName)
+	if err := deployer.Verify(domain, other); err == nil {
+		t.Error("Verify succeeded for a version the host does not serve")
+	}
+}
+
+// A wildcard is verified with the names of its check endpoints, never the bare parent domain
+func TestSSHVerifyWildcard(t *testing.T) {
+	dir := t.TempDir()
+	certs := certstore.New(filepath.Join(dir, "certs"), 5)
+	domain := utils.Domain{DomainName: "*.example.com"}
+	domain.SSH.Hosts = []string{"127.0.0.1:22"}
+	version := testVersion(t, certs, domain.DomainName, time.Now().Add(90*24*time.Hour), domain.DomainName)
+
+	served := serveVersion(t, version)
+	domain.SSH.VerifyPort = served.port
+	deployer := &sshDeployer{}
+
+	// Without check endpoints there is no concrete name to ask for
+	if err := deployer.Verify(domain, version); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if got := served.requested(); len(got) != 0 {
+		t.Fatalf("Verify asked for %q without check endpoints", got)
+	}
+
+	domain.Check.Endpoints = []string{"www.example.com", "api.example.com:8443", "www.example.com"}
+	if err := deployer.Verify(domain, version); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	want := []string{"www.example.com", "api.example.com"}
+	if got := served.requested(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Verify asked for %q, want %q", got, want)
+	}
+}
+
+func TestSSHDeployFailures(t *testing.T) {
+	dir := t.TempDir()
+	domain, signer := testSSHDomain(t, dir)
+	server := startSSHServer(t, signer.PublicKey())
+	domain.SSH.Hosts = []string{server.addr}
+
+	certs := certstore.New(filepath.Join(dir, "certs"), 5)
+	version := testVersion(t, certs, domain.DomainName, time.Now().Add(90*24*time.Hour), domain.DomainName)
+	deployer := &sshDeployer{}
+
+	// A host key that is not in known_hosts is refused before anything is uploaded
+	otherKey, _ := newTestSigner(t)
+	writeKnownHosts(t, domain.SSH.KnownHosts, server.addr, otherKey.PublicKey())
+	if err := deployer.Deploy(domain, version); err == nil {
+		t.Fatal("Deploy succeeded with an unknown host key")
+	}
+	if _, err := os.Stat(domain.SSH.FullChainPath); !os.IsNotExist(err) {
+		t.Errorf("files were uploaded to a host with an unknown host key")
+	}
+
+	writeKnownHosts(t, domain.SSH.KnownHosts, server.addr, server.hostKey.PublicKey())
+
+	// A failing pre command stops the deployment
+	domain.SSH.PreCommand = "false"
+	if err := deployer.Deploy(domain, version); err == nil || !strings.Contains(err.Error(), server.addr) {
+		t.Fatalf("Deploy = %v, want the failed host in the error", err)
+	}
+	if _, err := os.Stat(domain.SSH.FullChainPath); !os.IsNotExist(err) {
+		t.Errorf("files were uploaded although the pre command failed")
+	}
+
+	// A failing post command fails the deployment after the upload
+	domain.SSH.PreCommand = ""
+	domain.SSH.PostCommand = "false"
+	if err := deployer.Deploy(domain, version); err == nil {
+		t.Fatal("Deploy succeeded although the post command failed")
+	}
+	if _, err := os.Stat(domain.SSH.FullChainPath); err != nil {
+		t.Errorf("files were not uploaded before the post command: %v", err)
+	}
+}
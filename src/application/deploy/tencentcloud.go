@@ -0,0 +1,504 @@
+package deploy
+
+import (
+	"AutoCert/src/application/certstore"
+	"AutoCert/src/utils"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/profile"
+	ssl "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/ssl/v20191205"
+)
+
+// tencentCloudDomainResources are the resource types whose instances are
+// named by domain, a first deployment binds the certificate to them directly
+var tencentCloudDomainResources = map[string]bool{"cdn": true, "live": true, "vod": true, "waf": true}
+
+const (
+	tencentCloudRecordInterval = 10 * time.Second
+	tencentCloudRecordTimeout  = 10 * time.Minute
+	tencentCloudPageSize       = 100
+)
+
+// Status values of the resources in a Tencent Cloud deploy or update record
+const (
+	tencentCloudResourcePending = 0
+	tencentCloudResourceSuccess = 1
+	tencentCloudResourceFailed  = 2
+)
+
+func init() {
+	Register("tencentcloud", newTencentCloudDeployer)
+}
+
+// tencentCloudDeployer replaces the certificate of Tencent Cloud resources
+// (CDN, CLB, COS, ...) through the SSL certificate service. Resources bound
+// to an older certificate of the domain are switched with
+// UpdateCertificateInstance, or the instances listed in instance_ids are
+// deployed with DeployCertificateInstance. Without an older certificate the
+// new one is bound to the resources named by the domain.
+type tencentCloudDeployer struct {
+	client *ssl.Client
+
+	mu      sync.Mutex
+	certIds map[string]string               // certificate version to the Tencent Cloud certificate ID
+	records map[string][]tencentCloudRecord // certificate version to its deploy records
+}
+
+// tencentCloudRecord is a deploy record created for a certificate version,
+// update is set for records of UpdateCertificateInstance
+type tencentCloudRecord struct {
+	id     string
+	update bool
+}
+
+// tencentCloudResult is the outcome of a deploy record for a single resource
+type tencentCloudResult struct {
+	resourceType string
+	region       string
+	instance     string
+	status       int64
+	message      string
+}
+
+func (r tencentCloudResult) String() string {
+	name := r.resourceType + " " + r.instance
+	if r.region != "" {
+		name += " (" + r.region + ")"
+	}
+	if r.message != "" {
+		return name + ": " + r.message
+	}
+	return name
+}
+
+func newTencentCloudDeployer(config utils.Config) (Deployer, error) {
+	if config.TencentCloud.AccessKey == "" || config.TencentCloud.SecretKey == "" {
+		return nil, fmt.Errorf("tencentcloud access_key and secret_key are not configured")
+	}
+
+	credential := common.NewCredential(
+		config.TencentCloud.AccessKey,
+		config.TencentCloud.SecretKey,
+	)
+	cpf := profile.NewClientProfile()
+	cpf.HttpProfile.Endpoint = "ssl.tencentcloudapi.com"
+
+	client, err := ssl.NewClient(credential, "", cpf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SSL client: %v", err)
+	}
+
+	return &tencentCloudDeployer{
+		client:  client,
+		certIds: make(map[string]string),
+		records: make(map[string][]tencentCloudRecord),
+	}, nil
+}
+
+// certificateId returns the Tencent Cloud certificate ID of the version,
+// certificates issued elsewhere are uploaded once
+func (d *tencentCloudDeployer) certificateId(domain string, version *certstore.Version) (string, error) {
+	if version.Meta.Platform == "tencentcloud" && version.Meta.OrderID != "" {
+		return version.Meta.OrderID, nil
+	}
+
+	d.mu.Lock()
+	certId, ok := d.certIds[version.Dir]
+	d.mu.Unlock()
+	if ok {
+		return certId, nil
+	}
+
+	material, err := version.Load()
+	if err != nil {
+		return "", err
+	}
+
+	request := ssl.NewUploadCertificateRequest()
+	request.CertificatePublicKey = common.StringPtr(string(material.FullChainPEM))
+	request.CertificatePrivateKey = common.StringPtr(string(material.PrivateKeyPEM))
+	request.CertificateType = common.StringPtr("SVR")
+	request.Alias = common.StringPtr(certificateName(domain, version))
+
+	response, err := d.client.UploadCertificate(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload certificate: %v", err)
+	}
+
+	switch {
+	case response.Response.CertificateId != nil && *response.Response.CertificateId != "":
+		certId = *response.Response.CertificateId
+	case response.Response.RepeatCertId != nil && *response.Response.RepeatCertId != "":
+		certId = *response.Response.RepeatCertId
+	default:
+		return "", fmt.Errorf("certificate upload returned no certificate ID")
+	}
+	log.Printf("[INFO] Uploaded certificate version %s of domain %s to Tencent Cloud, Certificate ID: %s", version.Meta.Version, domain, certId)
+
+	d.mu.Lock()
+	d.certIds[version.Dir] = certId
+	d.mu.Unlock()
+	return certId, nil
+}
+
+// oldCertificates returns the IDs of the other issued certificates of the
+// domain that the new certificate with dnsNames can replace. A certificate
+// with a name the new one lacks, such as a shared wildcard replaced by a
+// single-name certificate, is left alone so its other hosts keep working.
+func (d *tencentCloudDeployer) oldCertificates(domain string, dnsNames []string, certId string) ([]string, error) {
+	var certIds []string
+	for offset := uint64(0); ; offset += tencentCloudPageSize {
+		request := ssl.NewDescribeCertificatesRequest()
+		request.SearchKey = common.StringPtr(strings.TrimPrefix(domain, "*."))
+		request.Offset = common.Uint64Ptr(offset)
+		request.Limit = common.Uint64Ptr(tencentCloudPageSize)
+
+		response, err := d.client.DescribeCertificates(request)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list certificates: %v", err)
+		}
+
+		for _, cert := range response.Response.Certificates {
+			if cert.CertificateId == nil || *cert.CertificateId == certId {
+				continue
+			}
+			if cert.Status == nil || *cert.Status != 1 {
+				continue
+			}
+
+			names := common.StringValues(cert.SubjectAltName)
+			if cert.Domain != nil {
+				names = append(names, *cert.Domain)
+			}
+			if !coversDomain(names, domain) {
+				continue
+			}
+			if !coversAll(dnsNames, names) {
+				log.Printf("[INFO] Keeping Tencent Cloud certificate %s, it covers %v beyond the new certificate", *cert.CertificateId, names)
+				continue
+			}
+			certIds = append(certIds, *cert.CertificateId)
+		}
+
+		if len(response.Response.Certificates) < tencentCloudPageSize ||
+			response.Response.TotalCount == nil || offset+tencentCloudPageSize >= *response.Response.TotalCount {
+			break
+		}
+	}
+	return certIds, nil
+}
+
+// updateInstances switches the resources bound to oldCertId over to certId
+func (d *tencentCloudDeployer) updateInstances(domain utils.Domain, oldCertId, certId string) (*tencentCloudRecord, error) {
+	resourceTypes := domain.TencentCloud.ResourceTypes
+	if len(resourceTypes) == 0 {
+		resourceTypes = []string{"cdn"}
+	}
+
+	request := ssl.NewUpdateCertificateInstanceRequest()
+	request.OldCertificateId = common.StringPtr(oldCertId)
+	request.CertificateId = common.StringPtr(certId)
+	request.ResourceTypes = common.StringPtrs(resourceTypes)
+	if len(domain.TencentCloud.Regions) > 0 {
+		for _, resourceType := range resourceTypes {
+			request.ResourceTypesRegions = append(request.ResourceTypesRegions, &ssl.ResourceTypeRegions{
+				ResourceType: common.StringPtr(resourceType),
+				Regions:      common.StringPtrs(domain.TencentCloud.Regions),
+			})
+		}
+	}
+
+	log.Printf("[INFO] Replacing Tencent Cloud certificate %s with %s on %v", oldCertId, certId, resourceTypes)
+	response, err := d.client.UpdateCertificateInstance(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to replace certificate %s: %v", oldCertId, err)
+	}
+
+	if response.Response.DeployRecordId == nil || *response.Response.DeployRecordId == 0 {
+		log.Printf("[INFO] No Tencent Cloud resource of %v uses certificate %s", resourceTypes, oldCertId)
+		return nil, nil
+	}
+	return &tencentCloudRecord{id: strconv.FormatUint(*response.Response.DeployRecordId, 10), update: true}, nil
+}
+
+// deployInstances deploys certId to the instances of a resource type
+func (d *tencentCloudDeployer) deployInstances(resourceType string, instanceIds []string, certId string) (*tencentCloudRecord, error) {
+	request := ssl.NewDeployCertificateInstanceRequest()
+	request.CertificateId = common.StringPtr(certId)
+	request.ResourceType = common.StringPtr(resourceType)
+	request.InstanceIdList = common.StringPtrs(instanceIds)
+
+	log.Printf("[INFO] Deploying Tencent Cloud certificate %s to %s instances %v", certId, resourceType, instanceIds)
+	response, err := d.client.DeployCertificateInstance(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deploy certificate %s: %v", certId, err)
+	}
+
+	if response.Response.DeployRecordId == nil || *response.Response.DeployRecordId == 0 {
+		return nil, fmt.Errorf("certificate deployment returned no deploy record")
+	}
+	return &tencentCloudRecord{id: strconv.FormatUint(*response.Response.DeployRecordId, 10)}, nil
+}
+
+// bindInstances deploys certId on the first deployment of a domain. Resources
+// named by domain are bound to the configured names of the domain, other
+// resource types need instance_ids and only keep the uploaded certificate.
+func (d *tencentCloudDeployer) bindInstances(domain utils.Domain, certId string) ([]tencentCloudRecord, error) {
+	resourceTypes := domain.TencentCloud.ResourceTypes
+	if len(resourceTypes) == 0 {
+		resourceTypes = []string{"cdn"}
+	}
+
+	var names []string
+	for _, name := range append([]string{domain.DomainName}, domain.AltNames...) {
+		if !strings.HasPrefix(name, "*.") {
+			names = append(names, name)
+		}
+	}
+
+	var records []tencentCloudRecord
+	for _, resourceType := range resourceTypes {
+		if !tencentCloudDomainResources[resourceType] || len(names) == 0 {
+			log.Printf("[WARN] Certificate %s is uploaded but not bound to %s, configure instance_ids to deploy it there", certId, resourceType)
+			continue
+		}
+		record, err := d.deployInstances(resourceType, names, certId)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, *record)
+	}
+	return records, nil
+}
+
+// results returns the per-resource outcome of a deploy record
+func (d *tencentCloudDeployer) results(record tencentCloudRecord) ([]tencentCloudResult, error) {
+	if record.update {
+		return d.updateResults(record.id)
+	}
+	return d.deployResults(record.id)
+}
+
+func (d *tencentCloudDeployer) updateResults(recordId string) ([]tencentCloudResult, error) {
+	var results []tencentCloudResult
+	for offset := 0; ; offset += tencentCloudPageSize {
+		request := ssl.NewDescribeHostUpdateRecordDetailRequest()
+		request.DeployRecordId = common.StringPtr(recordId)
+		request.Offset = common.StringPtr(strconv.Itoa(offset))
+		request.Limit = common.StringPtr(strconv.Itoa(tencentCloudPageSize))
+
+		response, err := d.client.DescribeHostUpdateRecordDetail(request)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe update record %s: %v", recordId, err)
+		}
+
+		count := 0
+		for _, group := range response.Response.RecordDetailList {
+			for _, item := range group.List {
+				count++
+				results = append(results, tencentCloudResult{
+					resourceType: stringValue(item.ResourceType),
+					region:       stringValue(item.Region),
+					instance:     firstNonEmpty(stringValue(item.InstanceId), strings.Join(common.StringValues(item.Domains), ",")),
+					status:       resourceStatus(item.Status),
+					message:      stringValue(item.ErrorMsg),
+				})
+			}
+		}
+
+		if count < tencentCloudPageSize {
+			break
+		}
+	}
+	return results, nil
+}
+
+func (d *tencentCloudDeployer) deployResults(recordId string) ([]tencentCloudResult, error) {
+	var results []tencentCloudResult
+	for offset := uint64(0); ; offset += tencentCloudPageSize {
+		request := ssl.NewDescribeHostDeployRecordDetailRequest()
+		request.DeployRecordId = common.StringPtr(recordId)
+		request.Offset = common.Uint64Ptr(offset)
+		request.Limit = common.Uint64Ptr(tencentCloudPageSize)
+
+		response, err := d.client.DescribeHostDeployRecordDetail(request)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe deploy record %s: %v", recordId, err)
+		}
+
+		for _, item := range response.Response.DeployRecordDetailList {
+			results = append(results, tencentCloudResult{
+				region:   stringValue(item.Region),
+				instance: firstNonEmpty(stringValue(item.InstanceId), strings.Join(common.StringValues(item.Domains), ",")),
+				status:   resourceStatus(item.Status),
+				message:  stringValue(item.ErrorMsg),
+			})
+		}
+
+		if len(response.Response.DeployRecordDetailList) < tencentCloudPageSize {
+			break
+		}
+	}
+	return results, nil
+}
+
+// waitForRecords polls the deploy records until no resource is pending,
+// logs the result of every resource and fails if any of them failed
+func (d *tencentCloudDeployer) waitForRecords(records []tencentCloudRecord) error {
+	deadline := time.Now().Add(tencentCloudRecordTimeout)
+	for {
+		var results []tencentCloudResult
+		for _, record := range records {
+			recordResults, err := d.results(record)
+			if err != nil {
+				return err
+			}
+			results = append(results, recordResults...)
+		}
+
+		pending := 0
+		for _, result := range results {
+			if result.status != tencentCloudResourceSuccess && result.status != tencentCloudResourceFailed {
+				pending++
+			}
+		}
+		if pending == 0 {
+			return reportResults(results)
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d Tencent Cloud resources still pending after %v", pending, tencentCloudRecordTimeout)
+		}
+		log.Printf("[INFO] Waiting for %d of %d Tencent Cloud resources", pending, len(results))
+		time.Sleep(tencentCloudRecordInterval)
+	}
+}
+
+// reportResults logs the outcome of each resource and returns an error listing the failed ones
+func reportResults(results []tencentCloudResult) error {
+	failed := []string{}
+	for _, result := range results {
+		if result.status == tencentCloudResourceSuccess {
+			log.Printf("[INFO] Tencent Cloud resource %s updated", result)
+			continue
+		}
+		log.Printf("[ERROR] Tencent Cloud resource %s failed", result)
+		failed = append(failed, result.String())
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d Tencent Cloud resources failed: %s", len(failed), len(results), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func (d *tencentCloudDeployer) Deploy(domain utils.Domain, version *certstore.Version) error {
+	certId, err := d.certificateId(domain.DomainName, version)
+	if err != nil {
+		return err
+	}
+
+	var records []tencentCloudRecord
+	if len(domain.TencentCloud.InstanceIds) > 0 {
+		if len(domain.TencentCloud.ResourceTypes) != 1 {
+			return fmt.Errorf("instance_ids requires exactly one resource type, got %v", domain.TencentCloud.ResourceTypes)
+		}
+		record, err := d.deployInstances(domain.TencentCloud.ResourceTypes[0], domain.TencentCloud.InstanceIds, certId)
+		if err != nil {
+			return err
+		}
+		records = append(records, *record)
+	} else {
+		oldCertIds, err := d.oldCertificates(domain.DomainName, version.Meta.DNSNames, certId)
+		if err != nil {
+			return err
+		}
+		if len(oldCertIds) == 0 {
+			log.Printf("[INFO] No earlier Tencent Cloud certificate of domain %s to replace, binding certificate %s directly", domain.DomainName, certId)
+			records, err = d.bindInstances(domain, certId)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, oldCertId := range oldCertIds {
+			record, err := d.updateInstances(domain, oldCertId, certId)
+			if err != nil {
+				return err
+			}
+			if record != nil {
+				records = append(records, *record)
+			}
+		}
+	}
+
+	d.mu.Lock()
+	d.records[version.Dir] = records
+	d.mu.Unlock()
+
+	if len(records) == 0 {
+		log.Printf("[WARN] No Tencent Cloud resource uses a certificate of domain %s", domain.DomainName)
+		return nil
+	}
+	return d.waitForRecords(records)
+}
+
+// Verify checks that every resource of the deploy records of the version was updated
+func (d *tencentCloudDeployer) Verify(domain utils.Domain, version *certstore.Version) error {
+	d.mu.Lock()
+	records, ok := d.records[version.Dir]
+	d.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("certificate version %s of domain %s was not deployed", version.Meta.Version, domain.DomainName)
+	}
+
+	var results []tencentCloudResult
+	for _, record := range records {
+		recordResults, err := d.results(record)
+		if err != nil {
+			return err
+		}
+		results = append(results, recordResults...)
+	}
+
+	for _, result := range results {
+		if result.status != tencentCloudResourceSuccess {
+			return fmt.Errorf("Tencent Cloud resource %s was not updated", result)
+		}
+	}
+	return nil
+}
+
+func (d *tencentCloudDeployer) Rollback(domain utils.Domain, previous *certstore.Version) error {
+	return d.Deploy(domain, previous)
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// resourceStatus returns the status of a record entry, a missing status counts as pending
+func resourceStatus[T int64 | uint64](status *T) int64 {
+	if status == nil {
+		return tencentCloudResourcePending
+	}
+	return int64(*status)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
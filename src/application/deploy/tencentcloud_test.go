@@ -0,0 +1,250 @@
+package deploy
+
+import (
+	"AutoCert/src/application/certstore"
+	"AutoCert/src/utils"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/profile"
+	ssl "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/ssl/v20191205"
+)
+
+// fakeTencentCloudCert is a certificate of the fake SSL service
+type fakeTencentCloudCert struct {
+	id     string
+	domain string
+	sans   []string
+	status int
+}
+
+// fakeTencentCloudUpdate is an UpdateCertificateInstance call of the fake
+type fakeTencentCloudUpdate struct {
+	oldCertId     string
+	certId        string
+	resourceTypes []string
+}
+
+// fakeTencentCloud serves the SSL certificate API actions used by the deployer,
+// every deploy record reports the resources with status resourceStatus
+type fakeTencentCloud struct {
+	mu             sync.Mutex
+	certs          []fakeTencentCloudCert
+	updates        []fakeTencentCloudUpdate
+	deploys        map[string][]string // resource type to the instances deployed to
+	resourceStatus int
+	nextId         int
+}
+
+func (f *fakeTencentCloud) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var params map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	str := func(name string) string {
+		var value string
+		json.Unmarshal(params[name], &value)
+		return value
+	}
+	strs := func(name string) []string {
+		var values []string
+		json.Unmarshal(params[name], &values)
+		return values
+	}
+
+	var response map[string]interface{}
+	switch action := r.Header.Get("X-TC-Action"); action {
+	case "UploadCertificate":
+		f.nextId++
+		id := "new" + strconv.Itoa(f.nextId)
+		f.certs = append(f.certs, fakeTencentCloudCert{id: id, domain: "uploaded", status: 1})
+		response = map[string]interface{}{"CertificateId": id}
+	case "DescribeCertificates":
+		certs := []interface{}{}
+		for _, cert := range f.certs {
+			if strings.Contains(cert.domain, str("SearchKey")) || strings.Contains(strings.Join(cert.sans, ","), str("SearchKey")) {
+				certs = append(certs, map[string]interface{}{
+					"CertificateId":  cert.id,
+					"Domain":         cert.domain,
+					"SubjectAltName": cert.sans,
+					"Status":         cert.status,
+				})
+			}
+		}
+		response = map[string]interface{}{"Certificates": certs, "TotalCount": len(certs)}
+	case "UpdateCertificateInstance":
+		f.updates = append(f.updates, fakeTencentCloudUpdate{oldCertId: str("OldCertificateId"), certId: str("CertificateId"), resourceTypes: strs("ResourceTypes")})
+		response = map[string]interface{}{"DeployRecordId": 1000 + len(f.updates)}
+	case "DeployCertificateInstance":
+		if f.deploys == nil {
+			f.deploys = map[string][]string{}
+		}
+		f.deploys[str("ResourceType")] = append(f.deploys[str("ResourceType")], strs("InstanceIdList")...)
+		response = map[string]interface{}{"DeployRecordId": 2000}
+	case "DescribeHostUpdateRecordDetail":
+		list := []interface{}{}
+		for _, update := range f.updates {
+			for _, resourceType := range update.resourceTypes {
+				list = append(list, map[string]interface{}{"ResourceType": resourceType, "InstanceId": update.oldCertId + "-" + resourceType, "Status": f.resourceStatus})
+			}
+		}
+		response = map[string]interface{}{"RecordDetailList": []interface{}{map[string]interface{}{"List": list}}}
+	case "DescribeHostDeployRecordDetail":
+		list := []interface{}{}
+		for _, instances := range f.deploys {
+			for _, instance := range instances {
+				list = append(list, map[string]interface{}{"InstanceId": instance, "Status": f.resourceStatus})
+			}
+		}
+		response = map[string]interface{}{"DeployRecordDetailList": list}
+	default:
+		response = map[string]interface{}{"Error": map[string]string{"Code": "InvalidAction", "Message": action}}
+	}
+
+	response["RequestId"] = "test"
+	json.NewEncoder(w).Encode(map[string]interface{}{"Response": response})
+}
+
+func newTestTencentCloud(t *testing.T, fake *fakeTencentCloud) *tencentCloudDeployer {
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	cpf := profile.NewClientProfile()
+	cpf.HttpProfile.Scheme = "HTTP"
+	cpf.HttpProfile.Endpoint = strings.TrimPrefix(server.URL, "http://")
+	client, err := ssl.NewClient(common.NewCredential("test-id", "test-key"), "", cpf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tencentCloudDeployer{
+		client:  client,
+		certIds: make(map[string]string),
+		records: make(map[string][]tencentCloudRecord),
+	}
+}
+
+func TestTencentCloudOldCertificates(t *testing.T) {
+	fake := &fakeTencentCloud{certs: []fakeTencentCloudCert{
+		{id: "old", domain: "www.example.com", status: 1},
+		{id: "sans", domain: "example.com", sans: []string{"example.com", "www.example.com"}, status: 1},
+		{id: "wildcard", domain: "*.example.com", status: 1},
+		{id: "pending", domain: "www.example.com", status: 0},
+		{id: "other", domain: "api.example.com", status: 1},
+		{id: "new", domain: "www.example.com", status: 1},
+	}}
+	deployer := newTestTencentCloud(t, fake)
+
+	// A certificate for www only replaces certificates without other names
+	certIds, err := deployer.oldCertificates("www.example.com", []string{"www.example.com"}, "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(certIds, ",") != "old" {
+		t.Errorf("oldCertificates = %v, want [old]", certIds)
+	}
+
+	// A certificate with more names replaces every certificate of the domain
+	certIds, err = deployer.oldCertificates("www.example.com", []string{"example.com", "*.example.com"}, "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(certIds, ",") != "old,sans" {
+		t.Errorf("oldCertificates = %v, want [old sans]", certIds)
+	}
+
+	// A wildcard domain only replaces certificates for the wildcard itself
+	certIds, err = deployer.oldCertificates("*.example.com", []string{"example.com", "*.example.com"}, "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(certIds, ",") != "wildcard" {
+		t.Errorf("oldCertificates = %v, want [wildcard]", certIds)
+	}
+}
+
+func TestTencentCloudDeployReplacesOldCertificate(t *testing.T) {
+	fake := &fakeTencentCloud{
+		certs:          []fakeTencentCloudCert{{id: "old", domain: "www.example.com", status: 1}},
+		resourceStatus: tencentCloudResourceSuccess,
+	}
+	deployer := newTestTencentCloud(t, fake)
+
+	certs := certstore.New(t.TempDir(), 5)
+	domain := utils.Domain{DomainName: "www.example.com"}
+	domain.TencentCloud.ResourceTypes = []string{"cdn", "clb"}
+	version := testVersion(t, certs, domain.DomainName, time.Now().Add(90*24*time.Hour), domain.DomainName)
+
+	if err := deployer.Deploy(domain, version); err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if err := deployer.Verify(domain, version); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	if len(fake.updates) != 1 {
+		t.Fatalf("replaced %d certificates, want 1", len(fake.updates))
+	}
+	update := fake.updates[0]
+	if update.oldCertId != "old" || update.certId != "new1" || strings.Join(update.resourceTypes, ",") != "cdn,clb" {
+		t.Errorf("UpdateCertificateInstance(%+v), want old replaced by new1 on cdn and clb", update)
+	}
+	if len(fake.deploys) != 0 {
+		t.Errorf("deployed to %v although an older certificate was replaced", fake.deploys)
+	}
+
+	other := testVersion(t, certs, domain.DomainName, time.Now().Add(80*24*time.Hour), domain.DomainName)
+	if err := deployer.Verify(domain, other); err == nil {
+		t.Error("Verify succeeded for a version that was not deployed")
+	}
+}
+
+func TestTencentCloudDeployReportsFailedResources(t *testing.T) {
+	fake := &fakeTencentCloud{
+		certs:          []fakeTencentCloudCert{{id: "old", domain: "www.example.com", status: 1}},
+		resourceStatus: tencentCloudResourceFailed,
+	}
+	deployer := newTestTencentCloud(t, fake)
+
+	certs := certstore.New(t.TempDir(), 5)
+	domain := utils.Domain{DomainName: "www.example.com"}
+	version := testVersion(t, certs, domain.DomainName, time.Now().Add(90*24*time.Hour), domain.DomainName)
+
+	err := deployer.Deploy(domain, version)
+	if err == nil || !strings.Contains(err.Error(), "old-cdn") {
+		t.Fatalf("Deploy = %v, want the failed resource in the error", err)
+	}
+	if err := deployer.Verify(domain, version); err == nil {
+		t.Error("Verify succeeded although the resource failed")
+	}
+}
+
+// Without an older certificate the new one is bound to the CDN domains of the configured names
+func TestTencentCloudFirstDeployBindsDomains(t *testing.T) {
+	fake := &fakeTencentCloud{resourceStatus: tencentCloudResourceSuccess}
+	deployer := newTestTencentCloud(t, fake)
+
+	certs := certstore.New(t.TempDir(), 5)
+	domain := utils.Domain{DomainName: "example.com", AltNames: []string{"*.example.com", "www.example.com"}}
+	version := testVersion(t, certs, domain.DomainName, time.Now().Add(90*24*time.Hour), "example.com", "*.example.com", "www.example.com")
+
+	if err := deployer.Deploy(domain, version); err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if len(fake.updates) != 0 {
+		t.Errorf("replaced %v without an older certificate", fake.updates)
+	}
+	if got := strings.Join(fake.deploys["cdn"], ","); got != "example.com,www.example.com" {
+		t.Errorf("deployed to CDN domains %s, want example.com,www.example.com", got)
+	}
+}
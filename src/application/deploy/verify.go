@@ -0,0 +1,111 @@
+package deploy
+
+import (
+	"AutoCert/src/application/certstore"
+	"AutoCert/src/utils"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultVerifyTimeout  = 10 * time.Minute
+	defaultVerifyInterval = 5 * time.Second
+	maxVerifyInterval     = time.Minute
+	verifyLookupTimeout   = 10 * time.Second
+	defaultVerifyPort     = 443
+)
+
+// verifyEndpoints returns the [domains.check] endpoints of the domain, where
+// its served certificate is verified after deployment. A wildcard domain
+// without check endpoints has no name to ask for and returns none.
+func verifyEndpoints(domain utils.Domain) []utils.CheckEndpoint {
+	if len(domain.Check.Endpoints) == 0 && strings.HasPrefix(domain.DomainName, "*.") {
+		return nil
+	}
+	return domain.CheckEndpoints(verifyLookupTimeout)
+}
+
+// servedFingerprint returns the SHA-256 fingerprint of the leaf certificate served at address for serverName
+func servedFingerprint(address, serverName string) (string, error) {
+	certs, err := utils.FetchPeerCertificates(address, serverName)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(certs[0].Raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// verifyServed waits until every endpoint of the domain serves the certificate
+// of the version. Endpoints are retried with exponential backoff so CDN edges
+// have time to pick up the new certificate, the deployment fails when they
+// have not converged within [deploy] verify_timeout.
+func verifyServed(config utils.Config, domain utils.Domain, version *certstore.Version) error {
+	endpoints := verifyEndpoints(domain)
+	if len(endpoints) == 0 {
+		log.Printf("[WARN] No check endpoints configured for wildcard domain %s, skipping served certificate check", domain.DomainName)
+		return nil
+	}
+	for _, target := range endpoints {
+		if target.Err != nil {
+			return fmt.Errorf("%s: %v", target.Address, target.Err)
+		}
+	}
+
+	timeout := config.Deploy.VerifyTimeout
+	if timeout <= 0 {
+		timeout = defaultVerifyTimeout
+	}
+	interval := config.Deploy.VerifyInterval
+	if interval <= 0 {
+		interval = defaultVerifyInterval
+	}
+
+	pending := make(map[string]utils.CheckEndpoint, len(endpoints))
+	for _, target := range endpoints {
+		pending[target.Address] = target
+	}
+
+	deadline := time.Now().Add(timeout)
+	failures := map[string]string{}
+	for {
+		for address, target := range pending {
+			fingerprint, err := servedFingerprint(target.Address, target.ServerName)
+			switch {
+			case err != nil:
+				failures[address] = err.Error()
+			case fingerprint != version.Meta.Fingerprint:
+				failures[address] = fmt.Sprintf("serves certificate %s", fingerprint)
+			default:
+				log.Printf("[INFO] %s serves certificate version %s of domain %s", address, version.Meta.Version, domain.DomainName)
+				delete(pending, address)
+				delete(failures, address)
+			}
+		}
+
+		if len(pending) == 0 {
+			return nil
+		}
+		if time.Now().Add(interval).After(deadline) {
+			break
+		}
+
+		log.Printf("[INFO] Waiting %v for %d endpoints of domain %s to serve version %s", interval, len(pending), domain.DomainName, version.Meta.Version)
+		time.Sleep(interval)
+		interval *= 2
+		if interval > maxVerifyInterval {
+			interval = maxVerifyInterval
+		}
+	}
+
+	details := make([]string, 0, len(failures))
+	for address, failure := range failures {
+		details = append(details, address+": "+failure)
+	}
+	sort.Strings(details)
+	return fmt.Errorf("certificate version %s not served after %v (%s)", version.Meta.Version, timeout, strings.Join(details, "; "))
+}
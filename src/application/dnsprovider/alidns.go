@@ -0,0 +1,262 @@
+package dnsprovider
+
+import (
+	"AutoCert/src/utils"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	alidns20150109 "github.com/alibabacloud-go/alidns-20150109/v4/client"
+	openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
+	util "github.com/alibabacloud-go/tea-utils/v2/service"
+	"github.com/alibabacloud-go/tea/tea"
+)
+
+const defaultAliDNSEndpoint = "alidns.cn-hangzhou.aliyuncs.com"
+
+func init() {
+	Register("aliyun", newAliDNSProvider)
+}
+
+// presentedRecords holds the IDs of the records written by Present in this
+// process, they are never overwritten. Providers are created per domain, so
+// the records of example.com and *.example.com share _acme-challenge.example.com
+// across provider instances.
+var presentedRecords = struct {
+	sync.Mutex
+	ids map[string]bool
+}{ids: map[string]bool{}}
+
+// aliDNSClient is the part of the AliDNS SDK client used by the provider
+type aliDNSClient interface {
+	AddDomainRecordWithOptions(request *alidns20150109.AddDomainRecordRequest, runtime *util.RuntimeOptions) (*alidns20150109.AddDomainRecordResponse, error)
+	UpdateDomainRecordWithOptions(request *alidns20150109.UpdateDomainRecordRequest, runtime *util.RuntimeOptions) (*alidns20150109.UpdateDomainRecordResponse, error)
+	DeleteDomainRecordWithOptions(request *alidns20150109.DeleteDomainRecordRequest, runtime *util.RuntimeOptions) (*alidns20150109.DeleteDomainRecordResponse, error)
+	DescribeDomainsWithOptions(request *alidns20150109.DescribeDomainsRequest, runtime *util.RuntimeOptions) (*alidns20150109.DescribeDomainsResponse, error)
+	DescribeDomainRecordsWithOptions(request *alidns20150109.DescribeDomainRecordsRequest, runtime *util.RuntimeOptions) (*alidns20150109.DescribeDomainRecordsResponse, error)
+}
+
+// aliDNSProvider manages records of zones hosted on Aliyun DNS
+type aliDNSProvider struct {
+	client aliDNSClient
+}
+
+func newAliDNSProvider(config utils.Config) (DNSProvider, error) {
+	client, err := createAliDNSClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AliDNS client: %v", err)
+	}
+	return &aliDNSProvider{client: client}, nil
+}
+
+func createAliDNSClient(config utils.Config) (*alidns20150109.Client, error) {
+	clientConfig := &openapi.Config{
+		AccessKeyId:     tea.String(config.Aliyun.AccessKey),
+		AccessKeySecret: tea.String(config.Aliyun.SecretKey),
+	}
+	clientConfig.Endpoint = tea.String(defaultAliDNSEndpoint)
+	if config.Aliyun.DNSEndpoint != "" {
+		clientConfig.Endpoint = tea.String(config.Aliyun.DNSEndpoint)
+	}
+	return alidns20150109.NewClient(clientConfig)
+}
+
+// Present is idempotent: an identical record is reused and a stale record
+// left by an earlier run is updated in place instead of adding a conflicting one
+func (p *aliDNSProvider) Present(zone, rr, recordType, value string) (string, error) {
+	existing, err := p.Records(zone, rr)
+	if err != nil {
+		return "", err
+	}
+
+	var stale *Record
+	for idx := range existing {
+		record := &existing[idx]
+		if !strings.EqualFold(record.RR, rr) || !strings.EqualFold(record.Type, recordType) {
+			continue
+		}
+		if record.Value == value {
+			log.Printf("[INFO] DNS record %s.%s %s already has the expected value, skipping\n", rr, zone, recordType)
+			rememberRecord(record.ID)
+			return record.ID, nil
+		}
+		// Records written by this run (e.g. the value for the wildcard of the same name) must stay
+		if stale == nil && !isPresented(record.ID) {
+			stale = record
+		}
+	}
+
+	if stale != nil {
+		return p.update(zone, stale.ID, rr, recordType, value)
+	}
+	return p.add(zone, rr, recordType, value)
+}
+
+func (p *aliDNSProvider) add(zone, rr, recordType, value string) (string, error) {
+	log.Printf("[INFO] Adding DNS record for domain %s: Type=%s, RR=%s, Value=%s\n", zone, recordType, rr, value)
+
+	addDomainRecordRequest := &alidns20150109.AddDomainRecordRequest{
+		DomainName: tea.String(zone),
+		RR:         tea.String(rr),
+		Type:       tea.String(recordType),
+		Value:      tea.String(value),
+	}
+
+	runtime := &util.RuntimeOptions{}
+	response, err := p.client.AddDomainRecordWithOptions(addDomainRecordRequest, runtime)
+	if err != nil {
+		return "", aliDNSError("add DNS record", err)
+	}
+
+	if response.Body == nil || response.Body.RecordId == nil {
+		return "", fmt.Errorf("DNS record added but no record ID returned")
+	}
+
+	recordId := tea.StringValue(response.Body.RecordId)
+	rememberRecord(recordId)
+	return recordId, nil
+}
+
+func (p *aliDNSProvider) update(zone, recordId, rr, recordType, value string) (string, error) {
+	log.Printf("[INFO] Updating DNS record %s for domain %s: Type=%s, RR=%s, Value=%s\n", recordId, zone, recordType, rr, value)
+
+	updateDomainRecordRequest := &alidns20150109.UpdateDomainRecordRequest{
+		RecordId: tea.String(recordId),
+		RR:       tea.String(rr),
+		Type:     tea.String(recordType),
+		Value:    tea.String(value),
+	}
+
+	runtime := &util.RuntimeOptions{}
+	if _, err := p.client.UpdateDomainRecordWithOptions(updateDomainRecordRequest, runtime); err != nil {
+		return "", aliDNSError("update DNS record", err)
+	}
+
+	rememberRecord(recordId)
+	return recordId, nil
+}
+
+func rememberRecord(recordId string) {
+	presentedRecords.Lock()
+	defer presentedRecords.Unlock()
+	presentedRecords.ids[recordId] = true
+}
+
+func isPresented(recordId string) bool {
+	presentedRecords.Lock()
+	defer presentedRecords.Unlock()
+	return presentedRecords.ids[recordId]
+}
+
+func (p *aliDNSProvider) CleanUp(zone, recordId string) error {
+	log.Printf("[INFO] Deleting DNS record %s from domain %s\n", recordId, zone)
+
+	deleteDomainRecordRequest := &alidns20150109.DeleteDomainRecordRequest{
+		RecordId: tea.String(recordId),
+	}
+
+	runtime := &util.RuntimeOptions{}
+	if _, err := p.client.DeleteDomainRecordWithOptions(deleteDomainRecordRequest, runtime); err != nil {
+		return aliDNSError("delete DNS record", err)
+	}
+
+	presentedRecords.Lock()
+	delete(presentedRecords.ids, recordId)
+	presentedRecords.Unlock()
+	return nil
+}
+
+func (p *aliDNSProvider) Zones() ([]string, error) {
+	var zones []string
+	runtime := &util.RuntimeOptions{}
+
+	for page := int64(1); ; page++ {
+		describeDomainsRequest := &alidns20150109.DescribeDomainsRequest{
+			PageNumber: tea.Int64(page),
+			PageSize:   tea.Int64(100),
+		}
+
+		response, err := p.client.DescribeDomainsWithOptions(describeDomainsRequest, runtime)
+		if err != nil {
+			return nil, aliDNSError("list AliDNS domains", err)
+		}
+		if response.Body == nil || response.Body.Domains == nil {
+			break
+		}
+
+		for _, domain := range response.Body.Domains.Domain {
+			zones = append(zones, tea.StringValue(domain.DomainName))
+		}
+
+		if int64(len(zones)) >= tea.Int64Value(response.Body.TotalCount) || len(response.Body.Domains.Domain) == 0 {
+			break
+		}
+	}
+
+	return zones, nil
+}
+
+func (p *aliDNSProvider) Records(zone, rrKeyword string) ([]Record, error) {
+	var records []Record
+	runtime := &util.RuntimeOptions{}
+
+	for page := int64(1); ; page++ {
+		describeDomainRecordsRequest := &alidns20150109.DescribeDomainRecordsRequest{
+			DomainName: tea.String(zone),
+			PageNumber: tea.Int64(page),
+			PageSize:   tea.Int64(500),
+		}
+		if rrKeyword != "" {
+			describeDomainRecordsRequest.RRKeyWord = tea.String(rrKeyword)
+		}
+
+		response, err := p.client.DescribeDomainRecordsWithOptions(describeDomainRecordsRequest, runtime)
+		if err != nil {
+			return nil, aliDNSError("list DNS records of "+zone, err)
+		}
+		if response.Body == nil || response.Body.DomainRecords == nil {
+			break
+		}
+
+		for _, record := range response.Body.DomainRecords.Record {
+			records = append(records, Record{
+				ID:    tea.StringValue(record.RecordId),
+				RR:    tea.StringValue(record.RR),
+				Type:  tea.StringValue(record.Type),
+				Value: tea.StringValue(record.Value),
+			})
+		}
+
+		if int64(len(records)) >= tea.Int64Value(response.Body.TotalCount) || len(response.Body.DomainRecords.Record) == 0 {
+			break
+		}
+	}
+
+	return records, nil
+}
+
+// aliDNSError maps Aliyun error codes to the provider independent error kinds
+func aliDNSError(op string, err error) error {
+	kind := ErrUnknown
+
+	var sdkErr *tea.SDKError
+	if errors.As(err, &sdkErr) {
+		code := tea.StringValue(sdkErr.Code)
+		switch {
+		case code == "DomainRecordDuplicate" || code == "DomainRecordConflict":
+			kind = ErrDuplicate
+		case strings.HasPrefix(code, "QuotaExceeded"):
+			kind = ErrQuota
+		case strings.HasPrefix(code, "InvalidAccessKeyId"),
+			strings.HasPrefix(code, "SignatureDoesNotMatch"),
+			strings.HasPrefix(code, "Forbidden"),
+			strings.HasPrefix(code, "InvalidSecurityToken"),
+			code == "IncorrectDomainUser":
+			kind = ErrAuth
+		}
+	}
+
+	return &Error{Kind: kind, Op: op, Err: err}
+}
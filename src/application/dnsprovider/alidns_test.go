@@ -0,0 +1,177 @@
+package dnsprovider
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	alidns20150109 "github.com/alibabacloud-go/alidns-20150109/v4/client"
+	util "github.com/alibabacloud-go/tea-utils/v2/service"
+	"github.com/alibabacloud-go/tea/tea"
+)
+
+// fakeAliDNS keeps the records of the zones in memory and counts the writes
+type fakeAliDNS struct {
+	mu      sync.Mutex
+	records []Record
+	nextId  int
+	adds    int
+	updates int
+}
+
+func (f *fakeAliDNS) AddDomainRecordWithOptions(request *alidns20150109.AddDomainRecordRequest, runtime *util.RuntimeOptions) (*alidns20150109.AddDomainRecordResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.adds++
+	f.nextId++
+	id := strconv.Itoa(f.nextId)
+	f.records = append(f.records, Record{ID: id, RR: tea.StringValue(request.RR), Type: tea.StringValue(request.Type), Value: tea.StringValue(request.Value)})
+	return &alidns20150109.AddDomainRecordResponse{Body: &alidns20150109.AddDomainRecordResponseBody{RecordId: tea.String(id)}}, nil
+}
+
+func (f *fakeAliDNS) UpdateDomainRecordWithOptions(request *alidns20150109.UpdateDomainRecordRequest, runtime *util.RuntimeOptions) (*alidns20150109.UpdateDomainRecordResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.updates++
+	for idx := range f.records {
+		if f.records[idx].ID == tea.StringValue(request.RecordId) {
+			f.records[idx].Value = tea.StringValue(request.Value)
+		}
+	}
+	return &alidns20150109.UpdateDomainRecordResponse{Body: &alidns20150109.UpdateDomainRecordResponseBody{RecordId: request.RecordId}}, nil
+}
+
+func (f *fakeAliDNS) DeleteDomainRecordWithOptions(request *alidns20150109.DeleteDomainRecordRequest, runtime *util.RuntimeOptions) (*alidns20150109.DeleteDomainRecordResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for idx := range f.records {
+		if f.records[idx].ID == tea.StringValue(request.RecordId) {
+			f.records = append(f.records[:idx], f.records[idx+1:]...)
+			break
+		}
+	}
+	return &alidns20150109.DeleteDomainRecordResponse{Body: &alidns20150109.DeleteDomainRecordResponseBody{RecordId: request.RecordId}}, nil
+}
+
+func (f *fakeAliDNS) DescribeDomainsWithOptions(request *alidns20150109.DescribeDomainsRequest, runtime *util.RuntimeOptions) (*alidns20150109.DescribeDomainsResponse, error) {
+	return &alidns20150109.DescribeDomainsResponse{Body: &alidns20150109.DescribeDomainsResponseBody{
+		Domains: &alidns20150109.DescribeDomainsResponseBodyDomains{
+			Domain: []*alidns20150109.DescribeDomainsResponseBodyDomainsDomain{{DomainName: tea.String("example.com")}},
+		},
+		TotalCount: tea.Int64(1),
+	}}, nil
+}
+
+func (f *fakeAliDNS) DescribeDomainRecordsWithOptions(request *alidns20150109.DescribeDomainRecordsRequest, runtime *util.RuntimeOptions) (*alidns20150109.DescribeDomainRecordsResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	records := []*alidns20150109.DescribeDomainRecordsResponseBodyDomainRecordsRecord{}
+	for _, record := range f.records {
+		if strings.Contains(record.RR, tea.StringValue(request.RRKeyWord)) {
+			records = append(records, &alidns20150109.DescribeDomainRecordsResponseBodyDomainRecordsRecord{
+				RecordId: tea.String(record.ID),
+				RR:       tea.String(record.RR),
+				Type:     tea.String(record.Type),
+				Value:    tea.String(record.Value),
+			})
+		}
+	}
+	return &alidns20150109.DescribeDomainRecordsResponse{Body: &alidns20150109.DescribeDomainRecordsResponseBody{
+		DomainRecords: &alidns20150109.DescribeDomainRecordsResponseBodyDomainRecords{Record: records},
+		TotalCount:    tea.Int64(int64(len(records))),
+	}}, nil
+}
+
+// values returns the values of the rr records
+func (f *fakeAliDNS) values(rr string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var values []string
+	for _, record := range f.records {
+		if record.RR == rr {
+			values = append(values, record.Value)
+		}
+	}
+	return values
+}
+
+func TestAliDNSPresent(t *testing.T) {
+	fake := &fakeAliDNS{
+		records: []Record{{ID: "stale", RR: "_acme-challenge.www", Type: "TXT", Value: "earlier run"}},
+		nextId:  100,
+	}
+	provider := &aliDNSProvider{client: fake}
+
+	// A record left by an earlier run is updated in place
+	id, err := provider.Present("example.com", "_acme-challenge.www", "TXT", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "stale" || fake.updates != 1 || fake.adds != 0 {
+		t.Fatalf("Present = %s with %d updates and %d adds, want the stale record updated", id, fake.updates, fake.adds)
+	}
+
+	// The same value is already present
+	if id, err := provider.Present("example.com", "_acme-challenge.www", "TXT", "token"); err != nil || id != "stale" {
+		t.Fatalf("Present = %s, %v, want the existing record", id, err)
+	}
+	if fake.updates != 1 || fake.adds != 0 {
+		t.Errorf("Present of an existing value wrote the record")
+	}
+
+	// Without a record a new one is added
+	id, err = provider.Present("example.com", "_acme-challenge.api", "TXT", "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "101" || fake.adds != 1 {
+		t.Errorf("Present = %s with %d adds, want a new record 101", id, fake.adds)
+	}
+
+	if err := provider.CleanUp("example.com", "stale"); err != nil {
+		t.Fatal(err)
+	}
+	if values := fake.values("_acme-challenge.www"); len(values) != 0 {
+		t.Errorf("CleanUp left %v", values)
+	}
+	if isPresented("stale") {
+		t.Errorf("CleanUp kept the record as presented")
+	}
+}
+
+// example.com and *.example.com are validated through different provider
+// instances but share the record name, neither may overwrite the other
+func TestAliDNSPresentSharedName(t *testing.T) {
+	fake := &fakeAliDNS{}
+	apex := &aliDNSProvider{client: fake}
+	wildcard := &aliDNSProvider{client: fake}
+
+	apexId, err := apex.Present("example.com", "_acme-challenge", "TXT", "apex token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wildcardId, err := wildcard.Present("example.com", "_acme-challenge", "TXT", "wildcard token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if apexId == wildcardId || fake.updates != 0 {
+		t.Fatalf("the wildcard value replaced the apex record %s", apexId)
+	}
+
+	values := fake.values("_acme-challenge")
+	if strings.Join(values, ",") != "apex token,wildcard token" {
+		t.Errorf("records hold %q, want both tokens", values)
+	}
+
+	for _, id := range []string{apexId, wildcardId} {
+		if err := apex.CleanUp("example.com", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
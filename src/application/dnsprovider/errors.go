@@ -0,0 +1,50 @@
+package dnsprovider
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorKind classifies provider failures so callers can decide whether a retry makes sense
+type ErrorKind int
+
+const (
+	ErrUnknown   ErrorKind = iota
+	ErrDuplicate           // the record already exists
+	ErrQuota               // the zone or account ran out of records
+	ErrAuth                // credentials are invalid or lack permission
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrDuplicate:
+		return "duplicate record"
+	case ErrQuota:
+		return "quota exceeded"
+	case ErrAuth:
+		return "authentication failed"
+	default:
+		return "unknown error"
+	}
+}
+
+// Error is returned by DNS providers for failed API calls
+type Error struct {
+	Kind ErrorKind
+	Op   string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("failed to %s (%s): %v", e.Op, e.Kind, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// IsKind reports whether err is a provider Error of the given kind
+func IsKind(err error, kind ErrorKind) bool {
+	var dnsErr *Error
+	return errors.As(err, &dnsErr) && dnsErr.Kind == kind
+}
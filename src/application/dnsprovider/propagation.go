@@ -0,0 +1,202 @@
+package dnsprovider
+
+import (
+	"AutoCert/src/utils"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultPropagationTimeout  = 10 * time.Minute
+	defaultPropagationInterval = 10 * time.Second
+	dnsQueryTimeout            = 5 * time.Second
+)
+
+// PropagationOptions controls how WaitForPropagation looks for a record
+type PropagationOptions struct {
+	// Resolvers are recursive resolvers (host:port) used to find the zone's nameservers
+	Resolvers []string
+	// Nameservers skips the NS lookup and queries these servers (host:port) directly
+	Nameservers []string
+	Timeout     time.Duration
+	Interval    time.Duration
+}
+
+// PropagationOptionsFromConfig builds the options from the [dns] section
+func PropagationOptionsFromConfig(config utils.Config) PropagationOptions {
+	return PropagationOptions{
+		Resolvers: config.DNS.Resolvers,
+		Timeout:   config.DNS.PropagationTimeout,
+		Interval:  config.DNS.PropagationInterval,
+	}
+}
+
+// WaitForPropagation blocks until every authoritative nameserver of zone
+// answers fqdn with value, or the timeout expires
+func WaitForPropagation(fqdn, zone, recordType, value string, opts PropagationOptions) error {
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultPropagationTimeout
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = defaultPropagationInterval
+	}
+	if len(opts.Resolvers) == 0 {
+		opts.Resolvers = systemResolvers()
+	}
+
+	qtype, ok := dns.StringToType[strings.ToUpper(recordType)]
+	if !ok {
+		return fmt.Errorf("unsupported record type %s", recordType)
+	}
+
+	nameservers := opts.Nameservers
+	if len(nameservers) == 0 {
+		var err error
+		nameservers, err = authoritativeNameservers(zone, opts.Resolvers)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("[INFO] Waiting for %s %s to propagate to %d nameservers of %s", fqdn, recordType, len(nameservers), zone)
+	deadline := time.Now().Add(opts.Timeout)
+	for {
+		pending := []string{}
+		for _, server := range nameservers {
+			values, err := queryRecord(server, fqdn, qtype, false)
+			if err != nil || !containsValue(values, value, qtype) {
+				pending = append(pending, server)
+			}
+		}
+
+		if len(pending) == 0 {
+			log.Printf("[INFO] %s %s is visible on all nameservers of %s", fqdn, recordType, zone)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s %s did not propagate within %s, still missing on %v", fqdn, recordType, opts.Timeout, pending)
+		}
+
+		log.Printf("[INFO] %s %s not yet visible on %v", fqdn, recordType, pending)
+		time.Sleep(opts.Interval)
+	}
+}
+
+// authoritativeNameservers resolves the NS records of zone to host:port addresses
+func authoritativeNameservers(zone string, resolvers []string) ([]string, error) {
+	hosts, err := resolve(resolvers, zone, dns.TypeNS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up nameservers of %s: %v", zone, err)
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no nameservers found for %s", zone)
+	}
+
+	var servers []string
+	for _, host := range hosts {
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			addrs, err := resolve(resolvers, host, qtype)
+			if err != nil {
+				continue
+			}
+			for _, addr := range addrs {
+				servers = append(servers, net.JoinHostPort(addr, "53"))
+			}
+		}
+	}
+
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("failed to resolve nameservers %v of %s", hosts, zone)
+	}
+	return servers, nil
+}
+
+// resolve asks the recursive resolvers in turn until one answers
+func resolve(resolvers []string, name string, qtype uint16) ([]string, error) {
+	var lastErr error
+	for _, resolver := range resolvers {
+		values, err := queryRecord(resolver, name, qtype, true)
+		if err == nil {
+			return values, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// queryRecord sends a single query to server and returns the answers of qtype as strings
+func queryRecord(server, name string, qtype uint16, recursive bool) ([]string, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+	msg.RecursionDesired = recursive
+
+	client := &dns.Client{Timeout: dnsQueryTimeout}
+	response, _, err := client.Exchange(msg, server)
+	if err == nil && response.Truncated {
+		client.Net = "tcp"
+		response, _, err = client.Exchange(msg, server)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if response.Rcode != dns.RcodeSuccess && response.Rcode != dns.RcodeNameError {
+		return nil, fmt.Errorf("%s answered %s for %s", server, dns.RcodeToString[response.Rcode], name)
+	}
+
+	var values []string
+	for _, rr := range response.Answer {
+		if rr.Header().Rrtype != qtype {
+			continue
+		}
+		switch record := rr.(type) {
+		case *dns.TXT:
+			values = append(values, strings.Join(record.Txt, ""))
+		case *dns.CNAME:
+			values = append(values, record.Target)
+		case *dns.NS:
+			values = append(values, record.Ns)
+		case *dns.A:
+			values = append(values, record.A.String())
+		case *dns.AAAA:
+			values = append(values, record.AAAA.String())
+		case *dns.SOA:
+			values = append(values, record.Hdr.Name)
+		}
+	}
+	return values, nil
+}
+
+// containsValue compares record values, host names are case and trailing dot insensitive
+func containsValue(values []string, expected string, qtype uint16) bool {
+	for _, value := range values {
+		if qtype == dns.TypeTXT {
+			if value == expected {
+				return true
+			}
+			continue
+		}
+		if strings.EqualFold(strings.TrimSuffix(value, "."), strings.TrimSuffix(expected, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// systemResolvers returns the nameservers from /etc/resolv.conf, public resolvers otherwise
+func systemResolvers() []string {
+	conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil || len(conf.Servers) == 0 {
+		return []string{"223.5.5.5:53", "8.8.8.8:53"}
+	}
+
+	servers := make([]string, 0, len(conf.Servers))
+	for _, server := range conf.Servers {
+		servers = append(servers, net.JoinHostPort(server, conf.Port))
+	}
+	return servers
+}
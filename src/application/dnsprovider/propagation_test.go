@@ -0,0 +1,196 @@
+package dnsprovider
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// testDNSServer answers queries from its records on a local UDP and TCP port
+type testDNSServer struct {
+	addr string
+
+	mu       sync.Mutex
+	records  map[string][]dns.RR // "name type" to the answers
+	truncate bool                // answer UDP queries truncated to force TCP
+}
+
+// startDNSServer starts an authoritative server for the given zone file lines
+func startDNSServer(t *testing.T, lines ...string) *testDNSServer {
+	t.Helper()
+
+	server := &testDNSServer{records: map[string][]dns.RR{}}
+	for _, line := range lines {
+		server.add(mustRR(t, line))
+	}
+
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.addr = packetConn.LocalAddr().String()
+	listener, err := net.Listen("tcp", server.addr)
+	if err != nil {
+		packetConn.Close()
+		t.Fatal(err)
+	}
+
+	for _, s := range []*dns.Server{
+		{PacketConn: packetConn, Handler: dns.HandlerFunc(server.serve)},
+		{Listener: listener, Handler: dns.HandlerFunc(server.serve)},
+	} {
+		started := make(chan struct{})
+		s.NotifyStartedFunc = func() { close(started) }
+		go s.ActivateAndServe()
+		<-started
+		t.Cleanup(func() { s.Shutdown() })
+	}
+	return server
+}
+
+// mustRR parses a record in zone file syntax
+func mustRR(t *testing.T, line string) dns.RR {
+	t.Helper()
+
+	rr, err := dns.NewRR(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+func (s *testDNSServer) add(rr dns.RR) {
+	key := strings.ToLower(rr.Header().Name) + " " + dns.TypeToString[rr.Header().Rrtype]
+
+	s.mu.Lock()
+	s.records[key] = append(s.records[key], rr)
+	s.mu.Unlock()
+}
+
+func (s *testDNSServer) serve(w dns.ResponseWriter, request *dns.Msg) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	response := new(dns.Msg)
+	response.SetReply(request)
+	response.Authoritative = true
+
+	question := request.Question[0]
+	if _, udp := w.RemoteAddr().(*net.UDPAddr); udp && s.truncate {
+		response.Truncated = true
+		w.WriteMsg(response)
+		return
+	}
+
+	answers := s.records[strings.ToLower(question.Name)+" "+dns.TypeToString[question.Qtype]]
+	if len(answers) == 0 {
+		response.Rcode = dns.RcodeNameError
+		// Like an authoritative server, name the closest enclosing zone by its SOA
+		for name := strings.ToLower(question.Name); ; {
+			if soa := s.records[name+" SOA"]; len(soa) > 0 {
+				response.Ns = append(response.Ns, soa...)
+				break
+			}
+			next, end := dns.NextLabel(name, 0)
+			if end {
+				break
+			}
+			name = name[next:]
+		}
+	}
+	response.Answer = append(response.Answer, answers...)
+	w.WriteMsg(response)
+}
+
+func TestWaitForPropagation(t *testing.T) {
+	fqdn := "_acme-challenge.www.example.com"
+	live := startDNSServer(t, fqdn+`. 60 IN TXT "token"`)
+	lagging := startDNSServer(t)
+
+	// The record shows up on the second nameserver a little later
+	record := mustRR(t, fqdn+`. 60 IN TXT "token"`)
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		lagging.add(record)
+	}()
+
+	opts := PropagationOptions{
+		Nameservers: []string{live.addr, lagging.addr},
+		Timeout:     5 * time.Second,
+		Interval:    100 * time.Millisecond,
+	}
+	if err := WaitForPropagation(fqdn, "example.com", "TXT", "token", opts); err != nil {
+		t.Fatalf("WaitForPropagation: %v", err)
+	}
+}
+
+func TestWaitForPropagationTimeout(t *testing.T) {
+	fqdn := "_acme-challenge.www.example.com"
+	live := startDNSServer(t, fqdn+`. 60 IN TXT "token"`)
+	stale := startDNSServer(t, fqdn+`. 60 IN TXT "previous"`)
+
+	opts := PropagationOptions{
+		Nameservers: []string{live.addr, stale.addr},
+		Timeout:     300 * time.Millisecond,
+		Interval:    100 * time.Millisecond,
+	}
+	err := WaitForPropagation(fqdn, "example.com", "TXT", "token", opts)
+	if err == nil {
+		t.Fatal("WaitForPropagation succeeded although a nameserver serves a stale value")
+	}
+	if !strings.Contains(err.Error(), stale.addr) || strings.Contains(err.Error(), live.addr) {
+		t.Errorf("error %q should only list the stale nameserver %s", err, stale.addr)
+	}
+}
+
+func TestWaitForPropagationCNAME(t *testing.T) {
+	fqdn := "_acme-challenge.api.example.com"
+	server := startDNSServer(t, fqdn+`. 60 IN CNAME API.acme-dns.example.net.`)
+
+	opts := PropagationOptions{Nameservers: []string{server.addr}, Timeout: time.Second, Interval: 100 * time.Millisecond}
+	if err := WaitForPropagation(fqdn, "example.com", "CNAME", "api.acme-dns.example.net", opts); err != nil {
+		t.Fatalf("WaitForPropagation: %v", err)
+	}
+}
+
+func TestAuthoritativeNameservers(t *testing.T) {
+	resolver := startDNSServer(t,
+		`example.com. 3600 IN NS ns1.example.net.`,
+		`example.com. 3600 IN NS ns2.example.net.`,
+		`ns1.example.net. 3600 IN A 192.0.2.1`,
+		`ns2.example.net. 3600 IN A 192.0.2.2`,
+		`ns2.example.net. 3600 IN AAAA 2001:db8::2`,
+	)
+
+	servers, err := authoritativeNameservers("example.com", []string{resolver.addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"192.0.2.1:53", "192.0.2.2:53", "[2001:db8::2]:53"}
+	if strings.Join(servers, ",") != strings.Join(want, ",") {
+		t.Errorf("authoritativeNameservers = %v, want %v", servers, want)
+	}
+
+	if _, err := authoritativeNameservers("example.org", []string{resolver.addr}); err == nil {
+		t.Error("authoritativeNameservers succeeded for a zone without NS records")
+	}
+}
+
+func TestQueryRecordFallsBackToTCP(t *testing.T) {
+	server := startDNSServer(t, `www.example.com. 60 IN TXT "over tcp"`)
+	server.mu.Lock()
+	server.truncate = true
+	server.mu.Unlock()
+
+	values, err := queryRecord(server.addr, "www.example.com", dns.TypeTXT, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 || values[0] != "over tcp" {
+		t.Errorf("queryRecord = %v, want [over tcp]", values)
+	}
+}
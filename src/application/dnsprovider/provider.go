@@ -0,0 +1,123 @@
+package dnsprovider
+
+import (
+	"AutoCert/src/utils"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Record is a DNS record as reported by a provider
+type Record struct {
+	ID    string
+	RR    string
+	Type  string
+	Value string
+}
+
+// DNSProvider writes DNS validation records into a zone hosted by a DNS platform
+type DNSProvider interface {
+	// Present creates the record rr.zone and returns the provider's record ID
+	Present(zone, rr, recordType, value string) (string, error)
+	// CleanUp removes a record previously created by Present
+	CleanUp(zone, recordId string) error
+	// Zones returns every zone hosted on the provider's account
+	Zones() ([]string, error)
+	// Records returns the records of zone whose RR contains rrKeyword, all records if empty
+	Records(zone, rrKeyword string) ([]Record, error)
+}
+
+// ProviderFactory creates a DNS provider for the given configuration
+type ProviderFactory func(config utils.Config) (DNSProvider, error)
+
+// defaultPlatform is used for domains without dns_platform, AliDNS was the only option before
+const defaultPlatform = "aliyun"
+
+var (
+	providerFactories = map[string]ProviderFactory{}
+	providerLock      sync.RWMutex
+)
+
+// Register makes a DNS provider available under the given dns_platform name
+func Register(platform string, factory ProviderFactory) {
+	providerLock.Lock()
+	defer providerLock.Unlock()
+
+	if _, exists := providerFactories[platform]; exists {
+		panic(fmt.Sprintf("DNS provider %q registered twice", platform))
+	}
+	providerFactories[platform] = factory
+}
+
+// Get returns the DNS provider registered for the given dns_platform
+func Get(config utils.Config, platform string) (DNSProvider, error) {
+	if platform == "" {
+		platform = defaultPlatform
+	}
+
+	providerLock.RLock()
+	factory, ok := providerFactories[platform]
+	providerLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unsupported DNS platform %q (available: %v)", platform, Registered())
+	}
+	return factory(config)
+}
+
+// ForDomain returns the DNS provider that hosts the zone of the domain
+func ForDomain(config utils.Config, domain utils.Domain) (DNSProvider, error) {
+	return Get(config, domain.DNSPlatform)
+}
+
+// Registered returns the sorted names of all registered DNS platforms
+func Registered() []string {
+	providerLock.RLock()
+	defer providerLock.RUnlock()
+
+	names := make([]string, 0, len(providerFactories))
+	for name := range providerFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FindZone returns the longest zone of the provider that fqdn belongs to
+func FindZone(provider DNSProvider, fqdn string) (string, error) {
+	zones, err := provider.Zones()
+	if err != nil {
+		return "", err
+	}
+
+	zone := longestZone(zones, normalizeName(fqdn))
+	if zone == "" {
+		return "", fmt.Errorf("no zone found for %s", fqdn)
+	}
+	return zone, nil
+}
+
+// validationPrefixes are the RR labels used by CA validation records:
+// _dnsauth for DigiCert/TrustAsia free certificates and _acme-challenge for ACME
+var validationPrefixes = []string{"_dnsauth", "_acme-challenge"}
+
+// IsValidationRecord reports whether the RR belongs to a certificate validation record
+func IsValidationRecord(rr string) bool {
+	rr = strings.ToLower(rr)
+	for _, prefix := range validationPrefixes {
+		if rr == prefix || strings.HasPrefix(rr, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// RelativeName returns the record name of fqdn inside zone, "@" for the apex
+func RelativeName(fqdn, zone string) string {
+	fqdn = strings.TrimSuffix(fqdn, ".")
+	if fqdn == zone {
+		return "@"
+	}
+	return strings.TrimSuffix(fqdn, "."+zone)
+}
@@ -0,0 +1,53 @@
+package dnsprovider
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// PruneZone deletes validation TXT records from zone whose ID is not in keep
+// and returns how many records were (or, with dryRun, would have been)
+// removed. Other record types under the validation labels, such as an
+// _acme-challenge CNAME delegating validation elsewhere, are never touched.
+func PruneZone(provider DNSProvider, zone string, keep map[string]bool, dryRun bool) (int, error) {
+	var candidates []Record
+	for _, prefix := range validationPrefixes {
+		records, err := provider.Records(zone, prefix)
+		if err != nil {
+			return 0, err
+		}
+		for _, record := range records {
+			if !strings.EqualFold(record.Type, "TXT") {
+				log.Printf("[INFO] Keeping %s record %s.%s, only TXT validation records are pruned", record.Type, record.RR, zone)
+				continue
+			}
+			if IsValidationRecord(record.RR) && !keep[record.ID] {
+				candidates = append(candidates, record)
+			}
+		}
+	}
+
+	pruned := 0
+	var lastErr error
+	for _, record := range candidates {
+		if dryRun {
+			log.Printf("[INFO] Would delete orphaned validation record %s.%s %s %s (Record ID: %s)", record.RR, zone, record.Type, record.Value, record.ID)
+			pruned++
+			continue
+		}
+
+		if err := provider.CleanUp(zone, record.ID); err != nil {
+			log.Printf("[ERROR] Failed to delete orphaned validation record %s.%s: %v", record.RR, zone, err)
+			lastErr = err
+			continue
+		}
+		log.Printf("[INFO] Deleted orphaned validation record %s.%s %s (Record ID: %s)", record.RR, zone, record.Type, record.ID)
+		pruned++
+	}
+
+	if lastErr != nil {
+		return pruned, fmt.Errorf("failed to prune some records in %s: %v", zone, lastErr)
+	}
+	return pruned, nil
+}
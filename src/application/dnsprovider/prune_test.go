@@ -0,0 +1,76 @@
+package dnsprovider
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+// fakeProvider keeps the records of a single zone in memory, zones lists
+// the hosted zones and defaults to example.com
+type fakeProvider struct {
+	records  []Record
+	deleted  []string
+	zones    []string
+	zonesErr error
+}
+
+func (p *fakeProvider) Present(zone, rr, recordType, value string) (string, error) {
+	return "", nil
+}
+
+func (p *fakeProvider) CleanUp(zone, recordId string) error {
+	p.deleted = append(p.deleted, recordId)
+	return nil
+}
+
+func (p *fakeProvider) Zones() ([]string, error) {
+	if p.zonesErr != nil {
+		return nil, p.zonesErr
+	}
+	if p.zones != nil {
+		return p.zones, nil
+	}
+	return []string{"example.com"}, nil
+}
+
+func (p *fakeProvider) Records(zone, rrKeyword string) ([]Record, error) {
+	var records []Record
+	for _, record := range p.records {
+		if strings.Contains(record.RR, rrKeyword) {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+func TestPruneZoneOnlyDeletesValidationTXT(t *testing.T) {
+	provider := &fakeProvider{records: []Record{
+		{ID: "1", RR: "_acme-challenge", Type: "TXT", Value: "orphan"},
+		{ID: "2", RR: "_acme-challenge.www", Type: "TXT", Value: "in-flight"},
+		{ID: "3", RR: "_acme-challenge.api", Type: "CNAME", Value: "api.acme-dns.example.net"},
+		{ID: "4", RR: "_dnsauth", Type: "txt", Value: "orphan"},
+	}}
+
+	count, err := PruneZone(provider, "example.com", map[string]bool{"2": true}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(provider.deleted)
+	if count != 2 || len(provider.deleted) != 2 || provider.deleted[0] != "1" || provider.deleted[1] != "4" {
+		t.Fatalf("pruned %d records %v, want 1 and 4", count, provider.deleted)
+	}
+}
+
+func TestPruneZoneDryRun(t *testing.T) {
+	provider := &fakeProvider{records: []Record{{ID: "1", RR: "_acme-challenge", Type: "TXT", Value: "orphan"}}}
+
+	count, err := PruneZone(provider, "example.com", nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || len(provider.deleted) != 0 {
+		t.Fatalf("dry run counted %d and deleted %v", count, provider.deleted)
+	}
+}
@@ -0,0 +1,118 @@
+package dnsprovider
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/publicsuffix"
+)
+
+// DetectZone determines the zone that hosts fqdn. The SOA walk finds the
+// closest zone cut, the public suffix list rejects answers above the
+// registrable domain, and the provider's zone list confirms the result.
+func DetectZone(provider DNSProvider, fqdn string, resolvers []string) (string, error) {
+	fqdn = normalizeName(fqdn)
+	if len(resolvers) == 0 {
+		resolvers = systemResolvers()
+	}
+
+	registrable, err := publicsuffix.EffectiveTLDPlusOne(fqdn)
+	if err != nil {
+		return "", fmt.Errorf("failed to determine registrable domain of %s: %v", fqdn, err)
+	}
+
+	soa, err := soaZone(fqdn, resolvers)
+	if err != nil {
+		log.Printf("[WARN] SOA lookup for %s failed: %v", fqdn, err)
+		soa = ""
+	} else if !isWithin(soa, registrable) {
+		log.Printf("[WARN] Ignoring SOA zone %s for %s, it is above the registrable domain %s", soa, fqdn, registrable)
+		soa = ""
+	}
+
+	zones, err := provider.Zones()
+	if err != nil {
+		// Without the provider's view fall back to DNS and the public suffix list alone
+		log.Printf("[WARN] Failed to list provider zones, detecting zone of %s from DNS only: %v", fqdn, err)
+		if soa != "" {
+			return soa, nil
+		}
+		return registrable, nil
+	}
+
+	if soa != "" && containsZone(zones, soa) {
+		return soa, nil
+	}
+
+	zone := longestZone(zones, fqdn)
+	if zone == "" {
+		return "", fmt.Errorf("no zone hosting %s found on the DNS provider (SOA: %q, registrable domain: %s)", fqdn, soa, registrable)
+	}
+	if soa != "" {
+		log.Printf("[WARN] %s is delegated to zone %s which is not hosted on the DNS provider, using %s", fqdn, soa, zone)
+	}
+	return zone, nil
+}
+
+// soaZone returns the owner of the SOA record that is authoritative for name
+func soaZone(name string, resolvers []string) (string, error) {
+	var lastErr error
+	for _, resolver := range resolvers {
+		msg := new(dns.Msg)
+		msg.SetQuestion(dns.Fqdn(name), dns.TypeSOA)
+
+		client := &dns.Client{Timeout: dnsQueryTimeout}
+		response, _, err := client.Exchange(msg, resolver)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if response.Rcode != dns.RcodeSuccess && response.Rcode != dns.RcodeNameError {
+			lastErr = fmt.Errorf("%s answered %s", resolver, dns.RcodeToString[response.Rcode])
+			continue
+		}
+
+		// The apex answers with its SOA, names below it carry the SOA in the authority section
+		for _, section := range [][]dns.RR{response.Answer, response.Ns} {
+			for _, rr := range section {
+				if soa, ok := rr.(*dns.SOA); ok {
+					return normalizeName(soa.Hdr.Name), nil
+				}
+			}
+		}
+		lastErr = fmt.Errorf("no SOA record in the answer of %s", resolver)
+	}
+	return "", lastErr
+}
+
+// longestZone returns the most specific zone that name belongs to
+func longestZone(zones []string, name string) string {
+	best := ""
+	for _, zone := range zones {
+		zone = normalizeName(zone)
+		if isWithin(name, zone) && len(zone) > len(best) {
+			best = zone
+		}
+	}
+	return best
+}
+
+func containsZone(zones []string, zone string) bool {
+	for _, z := range zones {
+		if normalizeName(z) == zone {
+			return true
+		}
+	}
+	return false
+}
+
+// isWithin reports whether name equals zone or is below it
+func isWithin(name, zone string) bool {
+	return name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+func normalizeName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimPrefix(name, "*.")), ".")
+}
@@ -0,0 +1,69 @@
+package dnsprovider
+
+import (
+	"errors"
+	"testing"
+)
+
+const testSOA = ` 3600 IN SOA ns1.example.net. hostmaster.example.net. 1 7200 900 1209600 300`
+
+func TestDetectZone(t *testing.T) {
+	resolver := startDNSServer(t,
+		`example.com.`+testSOA,
+		`dev.example.com.`+testSOA,
+		`example.org.`+testSOA,
+		`www.example.com. 60 IN A 192.0.2.1`,
+		`co.uk.`+testSOA,
+	)
+
+	tests := []struct {
+		name     string
+		fqdn     string
+		provider *fakeProvider
+		want     string
+		wantErr  bool
+	}{
+		// The apex answers with its own SOA
+		{"apex", "example.com", &fakeProvider{}, "example.com", false},
+		// An existing name below the apex carries the SOA in the authority section
+		{"existing name", "www.example.com", &fakeProvider{}, "example.com", false},
+		// A validation record that does not exist yet answers NXDOMAIN with the zone SOA
+		{"nxdomain authority", "_acme-challenge.www.example.com", &fakeProvider{}, "example.com", false},
+		{"wildcard", "*.example.com", &fakeProvider{}, "example.com", false},
+		{"delegated zone", "_acme-challenge.api.dev.example.com", &fakeProvider{zones: []string{"example.com", "dev.example.com"}}, "dev.example.com", false},
+		// A delegated zone that is not on the provider falls back to the longest hosted zone
+		{"delegation elsewhere", "_acme-challenge.api.dev.example.com", &fakeProvider{}, "example.com", false},
+		// An SOA above the registrable domain is ignored
+		{"soa above registrable", "_acme-challenge.www.example.co.uk", &fakeProvider{zones: []string{"example.co.uk"}}, "example.co.uk", false},
+		// Without the provider zone list the public suffix list decides
+		{"publicsuffix fallback", "_acme-challenge.www.example.co.uk", &fakeProvider{zonesErr: errors.New("unavailable")}, "example.co.uk", false},
+		{"soa without zone list", "_acme-challenge.api.dev.example.com", &fakeProvider{zonesErr: errors.New("unavailable")}, "dev.example.com", false},
+		{"not hosted", "_acme-challenge.www.example.org", &fakeProvider{}, "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			zone, err := DetectZone(test.provider, test.fqdn, []string{resolver.addr})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("DetectZone = %q, %v, want error %v", zone, err, test.wantErr)
+			}
+			if zone != test.want {
+				t.Errorf("DetectZone = %q, want %q", zone, test.want)
+			}
+		})
+	}
+}
+
+// Without any SOA answer the registrable domain is used when the provider cannot be asked
+func TestDetectZoneWithoutSOA(t *testing.T) {
+	resolver := startDNSServer(t)
+
+	zone, err := DetectZone(&fakeProvider{zonesErr: errors.New("unavailable")}, "_acme-challenge.www.example.com", []string{resolver.addr})
+	if err != nil || zone != "example.com" {
+		t.Errorf("DetectZone = %q, %v, want example.com", zone, err)
+	}
+
+	zone, err = DetectZone(&fakeProvider{zones: []string{"example.com", "www.example.com"}}, "_acme-challenge.www.example.com", []string{resolver.addr})
+	if err != nil || zone != "www.example.com" {
+		t.Errorf("DetectZone = %q, %v, want the longest hosted zone www.example.com", zone, err)
+	}
+}
@@ -0,0 +1,556 @@
+package request
+
+import (
+	"AutoCert/src/utils"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	defaultACMEDirectoryURL = acme.LetsEncryptURL
+	defaultACMEAccountKey   = "gitignore/acme/account.key"
+
+	// acmeRequestTimeout bounds every single round trip to the ACME server
+	acmeRequestTimeout = 2 * time.Minute
+)
+
+func init() {
+	RegisterIssuer("acme", newACMEIssuer)
+}
+
+// acmeRecord is a dns-01 record that must be live before its challenge is accepted
+type acmeRecord struct {
+	rr    string
+	value string
+}
+
+// acmeOrder is the in-memory progress of an ACME order between Apply and Download
+type acmeOrder struct {
+	authzURLs  []string
+	challenges []*acme.Challenge
+	records    []acmeRecord
+	accepted   bool
+
+	target    *dnsTarget
+	recordIds []string
+
+	privateKey *ecdsa.PrivateKey
+	der        [][]byte
+}
+
+// acmeIssuer obtains certificates from any RFC 8555 CA (Let's Encrypt, ZeroSSL,
+// Pebble, ...) and solves dns-01 challenges through the domain's DNS provider
+type acmeIssuer struct {
+	config utils.Config
+	dns    dnsTargets
+
+	mu     sync.Mutex
+	client *acme.Client
+	orders map[string]*acmeOrder
+}
+
+func newACMEIssuer(config utils.Config) CertificateIssuer {
+	return &acmeIssuer{
+		config: config,
+		orders: make(map[string]*acmeOrder),
+	}
+}
+
+// acmeClient returns a client with a registered account, creating the account key on first use
+func (i *acmeIssuer) acmeClient(ctx context.Context) (*acme.Client, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.client != nil {
+		return i.client, nil
+	}
+
+	directoryURL := i.config.ACME.DirectoryURL
+	if directoryURL == "" {
+		directoryURL = defaultACMEDirectoryURL
+	}
+
+	key, err := loadOrCreateACMEAccountKey(i.config.ACME.AccountKey)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient, err := newACMEHTTPClient(i.config.ACME.CABundle)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &acme.Client{
+		Key:          key,
+		DirectoryURL: directoryURL,
+		HTTPClient:   httpClient,
+		UserAgent:    "AutoCert",
+	}
+
+	account := &acme.Account{}
+	if i.config.ACME.Email != "" {
+		account.Contact = []string{"mailto:" + i.config.ACME.Email}
+	}
+	if i.config.ACME.EABKeyID != "" {
+		hmacKey, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(i.config.ACME.EABHMACKey, "="))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode ACME EAB HMAC key: %v", err)
+		}
+		account.ExternalAccountBinding = &acme.ExternalAccountBinding{
+			KID: i.config.ACME.EABKeyID,
+			Key: hmacKey,
+		}
+	}
+
+	log.Printf("[INFO] Registering ACME account at %s", directoryURL)
+	if _, err := client.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("failed to register ACME account: %v", err)
+	}
+
+	i.client = client
+	return client, nil
+}
+
+func (i *acmeIssuer) Apply(domain utils.Domain) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), acmeRequestTimeout)
+	defer cancel()
+
+	client, err := i.acmeClient(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	target, err := i.dns.get(i.config, domain)
+	if err != nil {
+		return "", err
+	}
+
+	names := append([]string{domain.DomainName}, domain.AltNames...)
+	log.Printf("[INFO] Creating ACME order for %v", names)
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(names...))
+	if err != nil {
+		return "", fmt.Errorf("failed to create ACME order: %v", err)
+	}
+
+	// The key is generated up front so it is persisted with the order before
+	// finalization and a run interrupted after it can still fetch the certificate
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate private key: %v", err)
+	}
+
+	state := &acmeOrder{authzURLs: order.AuthzURLs, target: target, privateKey: privateKey}
+	for _, authzURL := range order.AuthzURLs {
+		authz, err := client.GetAuthorization(ctx, authzURL)
+		if err != nil {
+			return "", fmt.Errorf("failed to get ACME authorization: %v", err)
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+
+		var challenge *acme.Challenge
+		for _, c := range authz.Challenges {
+			if c.Type == "dns-01" {
+				challenge = c
+				break
+			}
+		}
+		if challenge == nil {
+			return "", fmt.Errorf("no dns-01 challenge offered for %s", authz.Identifier.Value)
+		}
+
+		recordValue, err := client.DNS01ChallengeRecord(challenge.Token)
+		if err != nil {
+			return "", fmt.Errorf("failed to compute dns-01 record: %v", err)
+		}
+
+		rr := acmeChallengeRR(authz.Identifier.Value, target.zone)
+		recordId, err := target.present(rr, "TXT", recordValue)
+		if err != nil {
+			target.cleanUp(state.recordIds...)
+			return "", err
+		}
+
+		state.recordIds = append(state.recordIds, recordId)
+		state.records = append(state.records, acmeRecord{rr: rr, value: recordValue})
+		state.challenges = append(state.challenges, challenge)
+	}
+
+	i.mu.Lock()
+	i.orders[order.URI] = state
+	i.mu.Unlock()
+
+	return order.URI, nil
+}
+
+func (i *acmeIssuer) Poll(domain utils.Domain, orderURL string) (OrderStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), acmeRequestTimeout)
+	defer cancel()
+
+	client, err := i.acmeClient(ctx)
+	if err != nil {
+		return OrderFailed, err
+	}
+
+	i.mu.Lock()
+	state, ok := i.orders[orderURL]
+	i.mu.Unlock()
+	if !ok {
+		return OrderFailed, fmt.Errorf("unknown ACME order %s", orderURL)
+	}
+
+	// Tell the CA to validate only once all records are served by the authoritative nameservers
+	if !state.accepted {
+		for _, record := range state.records {
+			if err := state.target.waitForPropagation(record.rr, "TXT", record.value); err != nil {
+				log.Printf("[WARN] Not accepting ACME challenges for domain %s yet: %v", domain.DomainName, err)
+				return OrderPending, nil
+			}
+		}
+		for _, challenge := range state.challenges {
+			if _, err := client.Accept(ctx, challenge); err != nil {
+				return OrderFailed, fmt.Errorf("failed to accept ACME challenge: %v", err)
+			}
+		}
+		state.accepted = true
+	}
+
+	order, err := client.GetOrder(ctx, orderURL)
+	if err != nil {
+		return OrderFailed, fmt.Errorf("failed to get ACME order: %v", err)
+	}
+
+	if order.Status == acme.StatusInvalid {
+		i.cleanUpDNSRecords(state)
+		log.Printf("[ERROR] ACME order %s for domain %s became invalid", orderURL, domain.DomainName)
+		return OrderFailed, nil
+	}
+	if order.Status == acme.StatusPending {
+		return OrderPending, nil
+	}
+
+	// Every authorization is settled, the dns-01 records are no longer needed
+	i.cleanUpDNSRecords(state)
+
+	switch order.Status {
+	case acme.StatusProcessing:
+		return OrderPending, nil
+	case acme.StatusReady:
+		if err := i.finalize(ctx, client, domain, order, state); err != nil {
+			return OrderFailed, err
+		}
+		return OrderIssued, nil
+	case acme.StatusValid:
+		if state.der != nil {
+			return OrderIssued, nil
+		}
+		// Finalized by an interrupted run, fetch the certificate issued for the persisted key
+		if state.privateKey == nil {
+			log.Printf("[ERROR] ACME order %s is valid but its private key is not available", orderURL)
+			return OrderFailed, nil
+		}
+		der, err := client.FetchCert(ctx, order.CertURL, true)
+		if err != nil {
+			return OrderFailed, fmt.Errorf("failed to fetch ACME certificate: %v", err)
+		}
+		state.der = der
+		return OrderIssued, nil
+	default:
+		log.Printf("[ERROR] ACME order %s for domain %s has status %s", orderURL, domain.DomainName, order.Status)
+		return OrderFailed, nil
+	}
+}
+
+// finalize submits a CSR for the order's key and waits for the certificate
+func (i *acmeIssuer) finalize(ctx context.Context, client *acme.Client, domain utils.Domain, order *acme.Order, state *acmeOrder) error {
+	// Orders saved before the key was persisted with them get one now
+	if state.privateKey == nil {
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return fmt.Errorf("failed to generate private key: %v", err)
+		}
+		state.privateKey = privateKey
+	}
+
+	names := append([]string{domain.DomainName}, domain.AltNames...)
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domain.DomainName},
+		DNSNames: names,
+	}, state.privateKey)
+	if err != nil {
+		return fmt.Errorf("failed to create CSR: %v", err)
+	}
+
+	log.Printf("[INFO] Finalizing ACME order for domain %s", domain.DomainName)
+	der, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("failed to finalize ACME order: %v", err)
+	}
+
+	state.der = der
+	return nil
+}
+
+func (i *acmeIssuer) Download(domain utils.Domain, orderURL string) (*Certificate, error) {
+	i.mu.Lock()
+	state, ok := i.orders[orderURL]
+	i.mu.Unlock()
+	if !ok || len(state.der) == 0 {
+		return nil, fmt.Errorf("ACME order %s has no issued certificate", orderURL)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(state.privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode private key: %v", err)
+	}
+
+	var leaf, chain bytes.Buffer
+	pem.Encode(&leaf, &pem.Block{Type: "CERTIFICATE", Bytes: state.der[0]})
+	for _, der := range state.der[1:] {
+		pem.Encode(&chain, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+
+	return &Certificate{
+		Domain:         domain.DomainName,
+		CertificatePEM: leaf.Bytes(),
+		ChainPEM:       chain.Bytes(),
+		PrivateKeyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
+
+// Cancel deactivates the order's authorizations, ACME has no way to cancel an order itself
+func (i *acmeIssuer) Cancel(domain utils.Domain, orderURL string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), acmeRequestTimeout)
+	defer cancel()
+
+	client, err := i.acmeClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	state, ok := i.orders[orderURL]
+	i.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown ACME order %s", orderURL)
+	}
+
+	defer i.cleanUpDNSRecords(state)
+
+	for _, authzURL := range state.authzURLs {
+		if err := client.RevokeAuthorization(ctx, authzURL); err != nil {
+			return fmt.Errorf("failed to deactivate ACME authorization: %v", err)
+		}
+	}
+	return nil
+}
+
+// cleanUpDNSRecords deletes the dns-01 records of the order once
+func (i *acmeIssuer) cleanUpDNSRecords(state *acmeOrder) {
+	i.mu.Lock()
+	recordIds := state.recordIds
+	state.recordIds = nil
+	i.mu.Unlock()
+
+	state.target.cleanUp(recordIds...)
+}
+
+// acmeOrderData is the persisted form of acmeOrder, the private key is kept
+// so an order finalized by an interrupted run can still be completed
+type acmeOrderData struct {
+	AuthzURLs     []string `json:"authz_urls"`
+	ChallengeURLs []string `json:"challenge_urls"`
+	RecordNames   []string `json:"record_names"`
+	RecordValues  []string `json:"record_values"`
+	Accepted      bool     `json:"accepted"`
+	PrivateKey    string   `json:"private_key,omitempty"`
+}
+
+func (i *acmeIssuer) OrderState(orderURL string) OrderState {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	state, ok := i.orders[orderURL]
+	if !ok {
+		return OrderState{}
+	}
+
+	data := acmeOrderData{AuthzURLs: state.authzURLs, Accepted: state.accepted}
+	if state.privateKey != nil {
+		keyDER, err := x509.MarshalECPrivateKey(state.privateKey)
+		if err != nil {
+			log.Printf("[ERROR] Failed to encode ACME order private key: %v", err)
+		} else {
+			data.PrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+		}
+	}
+	for _, challenge := range state.challenges {
+		data.ChallengeURLs = append(data.ChallengeURLs, challenge.URI)
+	}
+	for _, record := range state.records {
+		data.RecordNames = append(data.RecordNames, record.rr)
+		data.RecordValues = append(data.RecordValues, record.value)
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("[ERROR] Failed to encode ACME order state: %v", err)
+		return OrderState{DNSRecords: state.recordIds}
+	}
+	return OrderState{
+		DNSRecords: append([]string{}, state.recordIds...),
+		Data:       map[string]string{"acme": string(encoded)},
+	}
+}
+
+func (i *acmeIssuer) RestoreOrder(domain utils.Domain, orderURL string, orderState OrderState) error {
+	var data acmeOrderData
+	if err := json.Unmarshal([]byte(orderState.Data["acme"]), &data); err != nil {
+		return fmt.Errorf("invalid ACME order state: %v", err)
+	}
+	if len(data.RecordNames) != len(data.RecordValues) {
+		return fmt.Errorf("invalid ACME order state: %d record names for %d values", len(data.RecordNames), len(data.RecordValues))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), acmeRequestTimeout)
+	defer cancel()
+
+	client, err := i.acmeClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	target, err := i.dns.get(i.config, domain)
+	if err != nil {
+		return err
+	}
+
+	state := &acmeOrder{
+		authzURLs: data.AuthzURLs,
+		accepted:  data.Accepted,
+		target:    target,
+		recordIds: orderState.DNSRecords,
+	}
+	if data.PrivateKey != "" {
+		block, _ := pem.Decode([]byte(data.PrivateKey))
+		if block == nil {
+			return fmt.Errorf("invalid ACME order state: private key is not PEM encoded")
+		}
+		privateKey, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("invalid ACME order state: %v", err)
+		}
+		state.privateKey = privateKey
+	}
+	for idx := range data.RecordNames {
+		state.records = append(state.records, acmeRecord{rr: data.RecordNames[idx], value: data.RecordValues[idx]})
+	}
+	for _, challengeURL := range data.ChallengeURLs {
+		challenge, err := client.GetChallenge(ctx, challengeURL)
+		if err != nil {
+			return fmt.Errorf("failed to get ACME challenge: %v", err)
+		}
+		state.challenges = append(state.challenges, challenge)
+	}
+
+	i.mu.Lock()
+	i.orders[orderURL] = state
+	i.mu.Unlock()
+	return nil
+}
+
+// acmeChallengeRR returns the record name of the dns-01 challenge relative to zone
+func acmeChallengeRR(identifier, zone string) string {
+	identifier = strings.TrimPrefix(identifier, "*.")
+	if identifier == zone {
+		return "_acme-challenge"
+	}
+	return "_acme-challenge." + strings.TrimSuffix(identifier, "."+zone)
+}
+
+// loadOrCreateACMEAccountKey reads the PEM encoded account key, generating it on first run
+func loadOrCreateACMEAccountKey(path string) (crypto.Signer, error) {
+	if path == "" {
+		path = defaultACMEAccountKey
+	}
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("invalid ACME account key in %s", path)
+		}
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ACME account key: %v", err)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read ACME account key: %v", err)
+	}
+
+	log.Printf("[INFO] Generating new ACME account key at %s", path)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ACME account key: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode ACME account key: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create ACME account key directory: %v", err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return nil, fmt.Errorf("failed to save ACME account key: %v", err)
+	}
+	return key, nil
+}
+
+// newACMEHTTPClient trusts caBundle in addition to the system roots, e.g. Pebble's minica
+func newACMEHTTPClient(caBundle string) (*http.Client, error) {
+	if caBundle == "" {
+		return http.DefaultClient, nil
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	data, err := os.ReadFile(caBundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ACME CA bundle: %v", err)
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in ACME CA bundle %s", caBundle)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: transport}, nil
+}
@@ -0,0 +1,187 @@
+package request
+
+import (
+	"AutoCert/src/application/dnsprovider"
+	"AutoCert/src/utils"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The tests in this file run against Pebble and pebble-challtestsrv, e.g.
+//
+//	pebble -config test/config/pebble-config.json -dnsserver 127.0.0.1:8053
+//	pebble-challtestsrv -defaultIPv6 "" -defaultIPv4 127.0.0.1
+//	PEBBLE_DIRECTORY=https://localhost:14000/dir PEBBLE_CA_BUNDLE=test/certs/pebble.minica.pem go test ./src/application/request/
+//
+// PEBBLE_CHALLTESTSRV (http://localhost:8055) and PEBBLE_DNS (127.0.0.1:8053)
+// locate pebble-challtestsrv when it does not run on its default ports.
+
+// challTestSrvProvider writes TXT records through the management API of pebble-challtestsrv
+type challTestSrvProvider struct {
+	url string
+}
+
+func (p *challTestSrvProvider) post(path, host, value string) error {
+	body, _ := json.Marshal(map[string]string{"host": host, "value": value})
+	resp, err := http.Post(p.url+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned HTTP %d", path, resp.StatusCode)
+	}
+	return nil
+}
+
+func (p *challTestSrvProvider) Present(zone, rr, recordType, value string) (string, error) {
+	host := rr + "." + zone + "."
+	return host, p.post("/set-txt", host, value)
+}
+
+func (p *challTestSrvProvider) CleanUp(zone, recordId string) error {
+	return p.post("/clear-txt", recordId, "")
+}
+
+func (p *challTestSrvProvider) Zones() ([]string, error) {
+	return []string{"example.com"}, nil
+}
+
+func (p *challTestSrvProvider) Records(zone, rrKeyword string) ([]dnsprovider.Record, error) {
+	return nil, nil
+}
+
+// newPebbleIssuer returns an ACME issuer for Pebble whose validation records
+// are served by pebble-challtestsrv, the test is skipped without Pebble
+func newPebbleIssuer(t *testing.T, domain utils.Domain) *acmeIssuer {
+	t.Helper()
+
+	directory := os.Getenv("PEBBLE_DIRECTORY")
+	if directory == "" {
+		t.Skip("PEBBLE_DIRECTORY is not set")
+	}
+	challTestSrv := os.Getenv("PEBBLE_CHALLTESTSRV")
+	if challTestSrv == "" {
+		challTestSrv = "http://localhost:8055"
+	}
+	nameserver := os.Getenv("PEBBLE_DNS")
+	if nameserver == "" {
+		nameserver = "127.0.0.1:8053"
+	}
+
+	var config utils.Config
+	config.ACME.DirectoryURL = directory
+	config.ACME.CABundle = os.Getenv("PEBBLE_CA_BUNDLE")
+	config.ACME.AccountKey = filepath.Join(t.TempDir(), "account.key")
+
+	issuer := newACMEIssuer(config).(*acmeIssuer)
+	issuer.dns.targets = map[string]*dnsTarget{
+		domain.DomainName: {
+			provider: &challTestSrvProvider{url: strings.TrimRight(challTestSrv, "/")},
+			zone:     "example.com",
+			propagation: dnsprovider.PropagationOptions{
+				Nameservers: []string{nameserver},
+				Timeout:     30 * time.Second,
+				Interval:    time.Second,
+			},
+		},
+	}
+	return issuer
+}
+
+// pollPebble polls the order like waitForOrder until it leaves OrderPending
+func pollPebble(t *testing.T, issuer *acmeIssuer, domain utils.Domain, orderURL string) OrderStatus {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Minute)
+	for {
+		status, err := issuer.Poll(domain, orderURL)
+		if err != nil {
+			t.Fatalf("Poll: %v", err)
+		}
+		if status != OrderPending {
+			return status
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("order %s is still pending", orderURL)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// checkPebbleCertificate checks that the downloaded certificate matches its key
+func checkPebbleCertificate(t *testing.T, issuer *acmeIssuer, domain utils.Domain, orderURL string) {
+	t.Helper()
+
+	cert, err := issuer.Download(domain, orderURL)
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	pair, err := tls.X509KeyPair(append(cert.CertificatePEM, cert.ChainPEM...), cert.PrivateKeyPEM)
+	if err != nil {
+		t.Fatalf("downloaded certificate does not match its key: %v", err)
+	}
+	if len(pair.Certificate) < 2 {
+		t.Errorf("downloaded certificate has no chain")
+	}
+}
+
+func TestACMEPebbleIssue(t *testing.T) {
+	domain := utils.Domain{DomainName: "issue.example.com"}
+	issuer := newPebbleIssuer(t, domain)
+
+	orderURL, err := issuer.Apply(domain)
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	status := pollPebble(t, issuer, domain, orderURL)
+	if status != OrderIssued {
+		t.Fatalf("order finished with status %s", status)
+	}
+	checkPebbleCertificate(t, issuer, domain, orderURL)
+
+	if records := issuer.OrderState(orderURL).DNSRecords; len(records) != 0 {
+		t.Errorf("validation records %v were not cleaned up", records)
+	}
+}
+
+// A run interrupted after finalization leaves a valid order without a
+// certificate in memory, the next run fetches it for the persisted key
+func TestACMEPebbleResumeAfterFinalize(t *testing.T) {
+	domain := utils.Domain{DomainName: "resume.example.com"}
+	issuer := newPebbleIssuer(t, domain)
+
+	orderURL, err := issuer.Apply(domain)
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	status := pollPebble(t, issuer, domain, orderURL)
+	if status != OrderIssued {
+		t.Fatalf("order finished with status %s", status)
+	}
+	// The state saved after the finalizing poll holds the key but no certificate
+	saved := issuer.OrderState(orderURL)
+
+	resumed := newPebbleIssuer(t, domain)
+	resumed.config.ACME.AccountKey = issuer.config.ACME.AccountKey
+	if err := resumed.RestoreOrder(domain, orderURL, saved); err != nil {
+		t.Fatalf("RestoreOrder: %v", err)
+	}
+	status, err = resumed.Poll(domain, orderURL)
+	if err != nil {
+		t.Fatalf("Poll of the resumed order: %v", err)
+	}
+	if status != OrderIssued {
+		t.Fatalf("resumed order finished with status %s", status)
+	}
+	checkPebbleCertificate(t, resumed, domain, orderURL)
+}
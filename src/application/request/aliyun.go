@@ -52,7 +52,7 @@ func ApplyAliyunSSLCertificate(domain string, config utils.Config) (string, erro
 	return orderId, nil
 }
 
-func DescribeAliyunCertificateState(orderId string, config utils.Config, baseDomain string) (string, string, string, string, error) {
+func DescribeAliyunCertificateState(orderId string, config utils.Config, zone string) (string, string, string, string, error) {
 	client, err := createClient(config)
 	if err != nil {
 		return "", "", "", "", fmt.Errorf("failed to create Aliyun client: %v", err)
@@ -96,8 +96,15 @@ func DescribeAliyunCertificateState(orderId string, config utils.Config, baseDom
 		return "", "", "", "", fmt.Errorf("empty response body")
 	}
 
-	recordDomain := tea.StringValue(response.Body.RecordDomain)
-	rr := strings.TrimSuffix(recordDomain, "."+baseDomain)
+	recordDomain := strings.TrimSuffix(tea.StringValue(response.Body.RecordDomain), ".")
+	rr := ""
+	if recordDomain != "" {
+		// A record outside the zone would silently produce a broken record, e.g. with a wrong base_domain
+		if !strings.HasSuffix(strings.ToLower(recordDomain), "."+zone) {
+			return "", "", "", "", fmt.Errorf("validation record %s is not inside zone %s", recordDomain, zone)
+		}
+		rr = recordDomain[:len(recordDomain)-len(zone)-1]
+	}
 
 	log.Printf("[INFO] Order ID: %d\n", orderIdInt)
 	log.Printf("[INFO] Certificate Status: %s\n", tea.StringValue(response.Body.Type))
@@ -106,7 +113,7 @@ func DescribeAliyunCertificateState(orderId string, config utils.Config, baseDom
 	log.Printf("[INFO] Record Domain: %s\n", recordDomain)
 	log.Printf("[INFO] Record Value: %s\n", tea.StringValue(response.Body.RecordValue))
 	log.Printf("[INFO] RR: %s\n", rr)
-	log.Printf("[INFO] Base Domain: %s\n", baseDomain)
+	log.Printf("[INFO] Zone: %s\n", zone)
 
 	return tea.StringValue(response.Body.Type),
 		tea.StringValue(response.Body.RecordType),
@@ -114,3 +121,85 @@ func DescribeAliyunCertificateState(orderId string, config utils.Config, baseDom
 		tea.StringValue(response.Body.RecordValue),
 		nil
 }
+
+// DownloadAliyunCertificate returns the PEM certificate chain and private key of an issued order.
+// DescribeCertificateState includes them once the status is "certificate", older orders are
+// looked up in the certificate list and fetched with GetUserCertificateDetail.
+func DownloadAliyunCertificate(orderId string, config utils.Config, domain string) (string, string, error) {
+	client, err := createClient(config)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create Aliyun client: %v", err)
+	}
+
+	orderIdInt, err := strconv.ParseInt(orderId, 10, 64)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to convert order ID: %v", err)
+	}
+
+	runtime := &util.RuntimeOptions{}
+
+	stateRequest := &cas20200407.DescribeCertificateStateRequest{
+		OrderId: tea.Int64(orderIdInt),
+	}
+	stateResponse, err := client.DescribeCertificateStateWithOptions(stateRequest, runtime)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to query certificate status: %v", err)
+	}
+	if stateResponse.Body != nil && tea.StringValue(stateResponse.Body.Certificate) != "" && tea.StringValue(stateResponse.Body.PrivateKey) != "" {
+		log.Printf("[INFO] Retrieved certificate for Order ID %s from certificate status\n", orderId)
+		return tea.StringValue(stateResponse.Body.Certificate), tea.StringValue(stateResponse.Body.PrivateKey), nil
+	}
+
+	certId, err := findAliyunCertificateId(client, orderIdInt, domain)
+	if err != nil {
+		return "", "", err
+	}
+
+	detailRequest := &cas20200407.GetUserCertificateDetailRequest{
+		CertId: tea.Int64(certId),
+	}
+	detailResponse, err := client.GetUserCertificateDetailWithOptions(detailRequest, runtime)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get certificate detail: %v", err)
+	}
+	if detailResponse.Body == nil || tea.StringValue(detailResponse.Body.Cert) == "" || tea.StringValue(detailResponse.Body.Key) == "" {
+		return "", "", fmt.Errorf("certificate %d has no certificate or private key", certId)
+	}
+
+	log.Printf("[INFO] Retrieved certificate %d for Order ID %s\n", certId, orderId)
+	return tea.StringValue(detailResponse.Body.Cert), tea.StringValue(detailResponse.Body.Key), nil
+}
+
+// findAliyunCertificateId returns the ID of the certificate issued for an order
+func findAliyunCertificateId(client *cas20200407.Client, orderId int64, domain string) (int64, error) {
+	runtime := &util.RuntimeOptions{}
+
+	for page := int64(1); ; page++ {
+		request := &cas20200407.ListUserCertificateOrderRequest{
+			Keyword:     tea.String(domain),
+			OrderType:   tea.String("CPACK"),
+			CurrentPage: tea.Int64(page),
+			ShowSize:    tea.Int64(50),
+		}
+
+		response, err := client.ListUserCertificateOrderWithOptions(request, runtime)
+		if err != nil {
+			return 0, fmt.Errorf("failed to list certificate orders: %v", err)
+		}
+		if response.Body == nil || len(response.Body.CertificateOrderList) == 0 {
+			break
+		}
+
+		for _, order := range response.Body.CertificateOrderList {
+			if tea.Int64Value(order.OrderId) == orderId && tea.Int64Value(order.CertificateId) != 0 {
+				return tea.Int64Value(order.CertificateId), nil
+			}
+		}
+
+		if page*50 >= tea.Int64Value(response.Body.TotalCount) {
+			break
+		}
+	}
+
+	return 0, fmt.Errorf("no certificate found for Order ID %d", orderId)
+}
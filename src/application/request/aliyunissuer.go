@@ -0,0 +1,178 @@
+package request
+
+import (
+	"AutoCert/src/utils"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+
+	cas20200407 "github.com/alibabacloud-go/cas-20200407/v3/client"
+	util "github.com/alibabacloud-go/tea-utils/v2/service"
+	"github.com/alibabacloud-go/tea/tea"
+)
+
+func init() {
+	RegisterIssuer("aliyun", newAliyunIssuer)
+}
+
+// aliyunIssuer requests free DigiCert certificates from Aliyun CAS and
+// answers the DNS validation through the domain's DNS provider
+type aliyunIssuer struct {
+	config utils.Config
+	dns    dnsTargets
+
+	mu         sync.Mutex
+	dnsRecords map[string]string // order ID to the ID of its validation record
+}
+
+func newAliyunIssuer(config utils.Config) CertificateIssuer {
+	return &aliyunIssuer{
+		config:     config,
+		dnsRecords: make(map[string]string),
+	}
+}
+
+func (i *aliyunIssuer) Apply(domain utils.Domain) (string, error) {
+	return ApplyAliyunSSLCertificate(domain.DomainName, i.config)
+}
+
+func (i *aliyunIssuer) Poll(domain utils.Domain, orderId string) (OrderStatus, error) {
+	target, err := i.dns.get(i.config, domain)
+	if err != nil {
+		return OrderFailed, err
+	}
+
+	status, recordType, rr, recordValue, err := DescribeAliyunCertificateState(orderId, i.config, target.zone)
+	if err != nil {
+		return OrderFailed, err
+	}
+
+	switch status {
+	case "domain_verify":
+		added, err := i.ensureDNSRecord(target, orderId, recordType, rr, recordValue)
+		if err != nil {
+			return OrderFailed, err
+		}
+		// CAS validates on its own schedule, at least do not report progress before the record is live
+		if added {
+			if err := target.waitForPropagation(rr, recordType, recordValue); err != nil {
+				log.Printf("[WARN] %v\n", err)
+			}
+		}
+		return OrderPending, nil
+	case "payed", "checking":
+		return OrderPending, nil
+	}
+
+	// The order has left domain_verify, the validation record is no longer needed
+	i.cleanUpDNSRecord(target, orderId)
+
+	switch status {
+	case "process":
+		return OrderPending, nil
+	case "certificate":
+		log.Printf("[INFO] Certificate issued for domain %s\n", domain.DomainName)
+		return OrderIssued, nil
+	default:
+		log.Printf("[WARN] Certificate status for domain %s: %s\n", domain.DomainName, status)
+		return OrderFailed, nil
+	}
+}
+
+// ensureDNSRecord adds the validation record the first time an order reaches
+// domain_verify and reports whether it was added by this call
+func (i *aliyunIssuer) ensureDNSRecord(target *dnsTarget, orderId, recordType, rr, recordValue string) (bool, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if _, ok := i.dnsRecords[orderId]; ok {
+		return false, nil
+	}
+
+	recordId, err := target.present(rr, recordType, recordValue)
+	if err != nil {
+		return false, err
+	}
+
+	i.dnsRecords[orderId] = recordId
+	return true, nil
+}
+
+// cleanUpDNSRecord deletes the validation record of the order if one was added
+func (i *aliyunIssuer) cleanUpDNSRecord(target *dnsTarget, orderId string) {
+	i.mu.Lock()
+	recordId, ok := i.dnsRecords[orderId]
+	delete(i.dnsRecords, orderId)
+	i.mu.Unlock()
+
+	if ok {
+		target.cleanUp(recordId)
+	}
+}
+
+func (i *aliyunIssuer) Download(domain utils.Domain, orderId string) (*Certificate, error) {
+	certificate, privateKey, err := DownloadAliyunCertificate(orderId, i.config, domain.DomainName)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf, chain, err := splitCertificateChain([]byte(certificate))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Certificate{
+		Domain:         domain.DomainName,
+		CertificatePEM: leaf,
+		ChainPEM:       chain,
+		PrivateKeyPEM:  []byte(privateKey),
+	}, nil
+}
+
+func (i *aliyunIssuer) Cancel(domain utils.Domain, orderId string) error {
+	client, err := createClient(i.config)
+	if err != nil {
+		return fmt.Errorf("failed to create Aliyun client: %v", err)
+	}
+
+	orderIdInt, err := strconv.ParseInt(orderId, 10, 64)
+	if err != nil {
+		return fmt.Errorf("failed to convert order ID: %v", err)
+	}
+
+	request := &cas20200407.CancelCertificateForPackageRequestRequest{
+		OrderId: tea.Int64(orderIdInt),
+	}
+	runtime := &util.RuntimeOptions{}
+
+	log.Printf("[INFO] Cancelling Aliyun certificate order %s for domain %s\n", orderId, domain.DomainName)
+	if _, err := client.CancelCertificateForPackageRequestWithOptions(request, runtime); err != nil {
+		return fmt.Errorf("failed to cancel Aliyun certificate order: %v", err)
+	}
+
+	if target, err := i.dns.get(i.config, domain); err == nil {
+		i.cleanUpDNSRecord(target, orderId)
+	}
+	return nil
+}
+
+func (i *aliyunIssuer) OrderState(orderId string) OrderState {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if recordId, ok := i.dnsRecords[orderId]; ok {
+		return OrderState{DNSRecords: []string{recordId}}
+	}
+	return OrderState{}
+}
+
+func (i *aliyunIssuer) RestoreOrder(domain utils.Domain, orderId string, state OrderState) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if len(state.DNSRecords) > 0 {
+		i.dnsRecords[orderId] = state.DNSRecords[0]
+	}
+	return nil
+}
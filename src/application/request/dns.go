@@ -0,0 +1,100 @@
+package request
+
+import (
+	"AutoCert/src/application/dnsprovider"
+	"AutoCert/src/utils"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// dnsTarget is where the validation records of a domain are written
+type dnsTarget struct {
+	provider    dnsprovider.DNSProvider
+	zone        string
+	propagation dnsprovider.PropagationOptions
+}
+
+// dnsTargets caches the DNS provider and zone of each domain so the zone
+// lookup is not repeated on every poll
+type dnsTargets struct {
+	mu      sync.Mutex
+	targets map[string]*dnsTarget
+}
+
+func (t *dnsTargets) get(config utils.Config, domain utils.Domain) (*dnsTarget, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if target, ok := t.targets[domain.DomainName]; ok {
+		return target, nil
+	}
+
+	provider, err := dnsprovider.ForDomain(config, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	// base_domain is only an override, the zone is detected from DNS and the provider otherwise
+	zone := strings.TrimSuffix(strings.ToLower(domain.BaseDomain), ".")
+	if zone == "" {
+		zone, err = dnsprovider.DetectZone(provider, domain.DomainName, config.DNS.Resolvers)
+		if err != nil {
+			return nil, fmt.Errorf("failed to detect DNS zone for domain %s: %v", domain.DomainName, err)
+		}
+		log.Printf("[INFO] Detected DNS zone %s for domain %s", zone, domain.DomainName)
+	} else {
+		name := strings.TrimPrefix(strings.ToLower(domain.DomainName), "*.")
+		if name != zone && !strings.HasSuffix(name, "."+zone) {
+			return nil, fmt.Errorf("base_domain %s of domain %s does not contain the domain", zone, domain.DomainName)
+		}
+	}
+
+	if t.targets == nil {
+		t.targets = make(map[string]*dnsTarget)
+	}
+	target := &dnsTarget{
+		provider:    provider,
+		zone:        zone,
+		propagation: dnsprovider.PropagationOptionsFromConfig(config),
+	}
+	t.targets[domain.DomainName] = target
+	return target, nil
+}
+
+// present writes a validation record and logs the manual steps when that fails
+func (t *dnsTarget) present(rr, recordType, value string) (string, error) {
+	recordId, err := t.provider.Present(t.zone, rr, recordType, value)
+	if dnsprovider.IsKind(err, dnsprovider.ErrAuth) {
+		log.Printf("[ERROR] DNS credentials were rejected for %s, check the access key and its DNS permissions: %v\n", t.zone, err)
+		return "", err
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to add DNS record for domain %s. Manual operation required:\n", t.zone)
+		log.Printf("Domain: %s\nRecord Type: %s\nRR: %s\nRecord Value: %s\n", t.zone, recordType, rr, value)
+		return "", err
+	}
+	log.Printf("[INFO] Successfully added DNS record %s.%s (Record ID: %s)\n", rr, t.zone, recordId)
+	return recordId, nil
+}
+
+// waitForPropagation blocks until the record is served by every authoritative nameserver
+func (t *dnsTarget) waitForPropagation(rr, recordType, value string) error {
+	fqdn := t.zone
+	if rr != "" && rr != "@" {
+		fqdn = rr + "." + t.zone
+	}
+	return dnsprovider.WaitForPropagation(fqdn, t.zone, recordType, value, t.propagation)
+}
+
+// cleanUp removes validation records once the CA no longer needs them
+func (t *dnsTarget) cleanUp(recordIds ...string) {
+	for _, recordId := range recordIds {
+		if err := t.provider.CleanUp(t.zone, recordId); err != nil {
+			log.Printf("[WARN] Failed to delete DNS record %s from %s, run \"dns prune\" to remove it later: %v", recordId, t.zone, err)
+			continue
+		}
+		log.Printf("[INFO] Deleted DNS record %s from %s", recordId, t.zone)
+	}
+}
@@ -0,0 +1,134 @@
+package request
+
+import (
+	"AutoCert/src/utils"
+	"bytes"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// OrderStatus is the provider independent state of a certificate order
+type OrderStatus int
+
+const (
+	OrderPending OrderStatus = iota
+	OrderIssued
+	OrderFailed
+)
+
+func (s OrderStatus) String() string {
+	switch s {
+	case OrderPending:
+		return "pending"
+	case OrderIssued:
+		return "issued"
+	case OrderFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// Certificate holds the PEM encoded material of an issued certificate
+type Certificate struct {
+	Domain         string
+	CertificatePEM []byte // leaf certificate only
+	ChainPEM       []byte // intermediate certificates, leaf excluded
+	PrivateKeyPEM  []byte
+}
+
+// CertificateIssuer is implemented by every certificate request platform.
+// The orchestrator calls Apply once, then Poll until the order leaves
+// OrderPending, and Download once it is issued. Cancel is used to give up
+// on an order that never completes.
+type CertificateIssuer interface {
+	Apply(domain utils.Domain) (string, error)
+	Poll(domain utils.Domain, orderId string) (OrderStatus, error)
+	Download(domain utils.Domain, orderId string) (*Certificate, error)
+	Cancel(domain utils.Domain, orderId string) error
+}
+
+// OrderState is the provider specific progress of an order that has to be
+// persisted so a later run can resume the order instead of applying again
+type OrderState struct {
+	DNSRecords []string
+	Data       map[string]string
+}
+
+// ResumableIssuer is implemented by issuers that keep per-order state in memory
+type ResumableIssuer interface {
+	OrderState(orderId string) OrderState
+	RestoreOrder(domain utils.Domain, orderId string, state OrderState) error
+}
+
+// IssuerFactory creates an issuer for the given configuration
+type IssuerFactory func(config utils.Config) CertificateIssuer
+
+var (
+	issuerFactories = map[string]IssuerFactory{}
+	issuerLock      sync.RWMutex
+)
+
+// RegisterIssuer makes an issuer available under the given request_platform name
+func RegisterIssuer(platform string, factory IssuerFactory) {
+	issuerLock.Lock()
+	defer issuerLock.Unlock()
+
+	if _, exists := issuerFactories[platform]; exists {
+		panic(fmt.Sprintf("certificate issuer %q registered twice", platform))
+	}
+	issuerFactories[platform] = factory
+}
+
+// GetIssuer returns the issuer registered for the given request_platform
+func GetIssuer(config utils.Config, platform string) (CertificateIssuer, error) {
+	issuerLock.RLock()
+	factory, ok := issuerFactories[platform]
+	issuerLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unsupported request platform %q (available: %v)", platform, RegisteredIssuers())
+	}
+	return factory(config), nil
+}
+
+// RegisteredIssuers returns the sorted names of all registered request platforms
+func RegisteredIssuers() []string {
+	issuerLock.RLock()
+	defer issuerLock.RUnlock()
+
+	names := make([]string, 0, len(issuerFactories))
+	for name := range issuerFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// splitCertificateChain splits a PEM bundle into the leaf certificate and the rest of the chain
+func splitCertificateChain(bundle []byte) ([]byte, []byte, error) {
+	var leaf, chain bytes.Buffer
+	rest := bundle
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if leaf.Len() == 0 {
+			pem.Encode(&leaf, block)
+		} else {
+			pem.Encode(&chain, block)
+		}
+	}
+
+	if leaf.Len() == 0 {
+		return nil, nil, fmt.Errorf("no certificate found in PEM bundle")
+	}
+	return leaf.Bytes(), chain.Bytes(), nil
+}
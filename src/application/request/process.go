@@ -0,0 +1,291 @@
+package request
+
+import (
+	"AutoCert/src/application/certstore"
+	"AutoCert/src/application/deploy"
+	"AutoCert/src/application/state"
+	"AutoCert/src/utils"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// pollInterval is the delay between two status checks of a pending order
+	pollInterval = 30 * time.Second
+	// orderTimeout is how long an order may stay pending before it is cancelled
+	orderTimeout = 24 * time.Hour
+)
+
+// ProcessCertificates resumes orders left over by an interrupted run, then
+// checks every configured domain and requests a new certificate from the
+// domain's request platform when it needs renewal. A domain serving a broken
+// chain gets its stored certificate deployed again.
+func ProcessCertificates(config utils.Config) {
+	log.Println("[INFO] Starting certificate processing")
+
+	store, err := state.Open(config.State.Path)
+	if err != nil {
+		log.Printf("[ERROR] Failed to open order state: %v", err)
+		return
+	}
+	certs := certstore.New(config.Store.Root, config.Store.Retention)
+
+	var wg sync.WaitGroup
+
+	// Resume first so an interrupted order does not burn another free certificate quota slot
+	inFlight := map[string]bool{}
+	for _, order := range store.List() {
+		domain, ok := findDomain(config, order.Domain)
+		if !ok {
+			log.Printf("[WARN] Dropping order %s, domain %s is no longer configured", order.OrderID, order.Domain)
+			deleteOrder(store, order.Domain)
+			continue
+		}
+
+		issuer, err := GetIssuer(config, order.Platform)
+		if err != nil {
+			log.Printf("[ERROR] Cannot resume order %s for domain %s: %v", order.OrderID, order.Domain, err)
+			continue
+		}
+
+		if resumable, ok := issuer.(ResumableIssuer); ok {
+			orderState := OrderState{DNSRecords: order.DNSRecords, Data: order.Data}
+			if err := resumable.RestoreOrder(domain, order.OrderID, orderState); err != nil {
+				log.Printf("[ERROR] Failed to restore order %s for domain %s, dropping it: %v", order.OrderID, order.Domain, err)
+				deleteOrder(store, order.Domain)
+				continue
+			}
+		}
+
+		log.Printf("[INFO] Resuming %s order %s for domain %s (status %s, applied %s)", order.Platform, order.OrderID, order.Domain, order.Status, order.CreatedAt.Format("2006-01-02 15:04:05"))
+		inFlight[order.Domain] = true
+
+		wg.Add(1)
+		go func(domain utils.Domain, order state.Order) {
+			defer wg.Done()
+			completeOrder(config, store, certs, issuer, domain, order)
+		}(domain, order)
+	}
+
+	results := utils.CheckSSLCertificates(config)
+	counts := map[utils.CheckStatus]int{}
+	domainsToRenew := []string{}
+	domainsToRedeploy := []string{}
+	reachable := map[string]bool{}
+	for _, result := range results {
+		counts[result.Status]++
+		if result.NeedsRenewal() {
+			domainsToRenew = append(domainsToRenew, result.Domain)
+		} else if result.NeedsRedeploy() {
+			domainsToRedeploy = append(domainsToRedeploy, result.Domain)
+		}
+		if result.Status != utils.CheckError {
+			reachable[result.Domain] = true
+		}
+	}
+	log.Printf("[INFO] Certificate check results: %d expiring, %d expired, %d invalid, %d with errors", counts[utils.CheckExpiring], counts[utils.CheckExpired], counts[utils.CheckInvalid], counts[utils.CheckError])
+
+	// Check errors only raise an alert, a domain is only requested because of
+	// them when no endpoint answered and no certificate was ever stored for it,
+	// i.e. it is not serving TLS yet
+	for _, domain := range config.Domains {
+		if reachable[domain.DomainName] || contains(domainsToRenew, domain.DomainName) {
+			continue
+		}
+		if _, err := certs.Current(domain.DomainName); err == nil {
+			log.Printf("[WARN] No endpoint of domain %s could be checked, not renewing its stored certificate", domain.DomainName)
+			continue
+		}
+		log.Printf("[INFO] Domain %s serves no certificate and none is stored, requesting the first one", domain.DomainName)
+		domainsToRenew = append(domainsToRenew, domain.DomainName)
+	}
+
+	for _, domain := range config.Domains {
+		if inFlight[domain.DomainName] {
+			continue
+		}
+
+		// A broken served chain is fixed by deploying the stored full chain
+		// again, a new certificate is only requested when none is stored
+		renew := contains(domainsToRenew, domain.DomainName)
+		if !renew && contains(domainsToRedeploy, domain.DomainName) {
+			if current, err := certs.Current(domain.DomainName); err == nil {
+				if storedChainBroken(config, domain, current) {
+					continue
+				}
+				wg.Add(1)
+				go func(domain utils.Domain) {
+					defer wg.Done()
+					log.Printf("[INFO] Redeploying the stored certificate of domain %s to fix the served chain", domain.DomainName)
+					if err := deploy.DeployDomain(config, certs, domain); err != nil {
+						log.Printf("[ERROR] %v", err)
+					}
+				}(domain)
+				continue
+			}
+			log.Printf("[WARN] No stored certificate to redeploy for domain %s, requesting a new one", domain.DomainName)
+			renew = true
+		}
+		if !renew {
+			continue
+		}
+
+		issuer, err := GetIssuer(config, domain.RequestPlatform)
+		if err != nil {
+			log.Printf("[ERROR] Skipping domain %s: %v", domain.DomainName, err)
+			continue
+		}
+
+		wg.Add(1)
+		go func(domain utils.Domain) {
+			defer wg.Done()
+			processDomain(config, store, certs, issuer, domain)
+		}(domain)
+	}
+
+	log.Println("[INFO] Waiting for all certificate orders to complete")
+	wg.Wait()
+
+	log.Println("[INFO] Completed certificate processing")
+}
+
+// storedChainBroken reports whether the stored version has a chain problem
+// itself. Deploying it again would then not fix the served chain and only be
+// repeated on every run, so the problem is reported instead.
+func storedChainBroken(config utils.Config, domain utils.Domain, version *certstore.Version) bool {
+	material, err := version.Load()
+	if err != nil {
+		log.Printf("[ERROR] Cannot check the stored chain of domain %s, not redeploying it: %v", domain.DomainName, err)
+		return true
+	}
+	issues, err := utils.ChainIssues(config, material.FullChainPEM)
+	if err != nil {
+		log.Printf("[ERROR] Cannot check the stored chain of domain %s, not redeploying it: %v", domain.DomainName, err)
+		return true
+	}
+
+	for _, issue := range issues {
+		if issue.NeedsRedeploy() {
+			log.Printf("[ERROR] Stored certificate version %s of domain %s has the chain issues %v itself, fix its chain or renew it manually", version.Meta.Version, domain.DomainName, issues)
+			return true
+		}
+	}
+	return false
+}
+
+// processDomain applies for a new certificate and drives the order to completion
+func processDomain(config utils.Config, store *state.Store, certs *certstore.Store, issuer CertificateIssuer, domain utils.Domain) {
+	log.Printf("[INFO] Applying for certificate for domain %s via %s", domain.DomainName, domain.RequestPlatform)
+	orderId, err := issuer.Apply(domain)
+	if err != nil {
+		log.Printf("[ERROR] Failed to apply for certificate for domain %s: %v", domain.DomainName, err)
+		return
+	}
+	log.Printf("[INFO] Certificate application submitted for domain %s, Order ID: %s", domain.DomainName, orderId)
+
+	order := state.Order{
+		Platform:  domain.RequestPlatform,
+		OrderID:   orderId,
+		Domain:    domain.DomainName,
+		CreatedAt: time.Now(),
+	}
+	saveOrder(store, issuer, &order, OrderPending)
+
+	completeOrder(config, store, certs, issuer, domain, order)
+}
+
+// completeOrder polls the order, saves the certificate into the certificate
+// store once it is issued and deploys it. The order stays in the state store
+// when a transient error interrupts it.
+func completeOrder(config utils.Config, store *state.Store, certs *certstore.Store, issuer CertificateIssuer, domain utils.Domain, order state.Order) {
+	status, err := waitForOrder(store, issuer, domain, &order)
+	if err != nil {
+		log.Printf("[ERROR] Failed to check certificate status for domain %s, will resume on the next run: %v", domain.DomainName, err)
+		return
+	}
+	if status != OrderIssued {
+		log.Printf("[ERROR] Certificate order %s for domain %s finished with status %s", order.OrderID, domain.DomainName, status)
+		deleteOrder(store, domain.DomainName)
+		return
+	}
+
+	cert, err := issuer.Download(domain, order.OrderID)
+	if err != nil {
+		log.Printf("[ERROR] Failed to download certificate for domain %s, will retry on the next run: %v", domain.DomainName, err)
+		return
+	}
+	log.Printf("[INFO] Successfully retrieved certificate for domain %s", cert.Domain)
+
+	meta := certstore.Meta{Platform: order.Platform, OrderID: order.OrderID}
+	version, err := certs.Save(domain.DomainName, cert.CertificatePEM, cert.ChainPEM, cert.PrivateKeyPEM, meta)
+	if err != nil {
+		log.Printf("[ERROR] Failed to store certificate for domain %s, will retry on the next run: %v", domain.DomainName, err)
+		return
+	}
+	log.Printf("[INFO] Certificate for domain %s stored in %s (expires %s)", domain.DomainName, version.Dir, version.Meta.NotAfter.Format("2006-01-02 15:04:05"))
+
+	deleteOrder(store, domain.DomainName)
+
+	if err := deploy.DeployDomain(config, certs, domain); err != nil {
+		log.Printf("[ERROR] %v", err)
+	}
+}
+
+// waitForOrder polls the order until it leaves OrderPending, cancelling it
+// once it has been pending for orderTimeout since it was applied
+func waitForOrder(store *state.Store, issuer CertificateIssuer, domain utils.Domain, order *state.Order) (OrderStatus, error) {
+	deadline := order.CreatedAt.Add(orderTimeout)
+	for {
+		status, err := issuer.Poll(domain, order.OrderID)
+		if err != nil {
+			return OrderFailed, err
+		}
+		saveOrder(store, issuer, order, status)
+
+		if status != OrderPending {
+			return status, nil
+		}
+
+		if time.Now().After(deadline) {
+			log.Printf("[WARN] Certificate order %s for domain %s is still pending after %s, cancelling", order.OrderID, domain.DomainName, orderTimeout)
+			if err := issuer.Cancel(domain, order.OrderID); err != nil {
+				log.Printf("[ERROR] Failed to cancel order %s: %v", order.OrderID, err)
+			}
+			return OrderFailed, nil
+		}
+
+		log.Printf("[INFO] Certificate order %s for domain %s is still pending", order.OrderID, domain.DomainName)
+		time.Sleep(pollInterval)
+	}
+}
+
+// saveOrder persists the order together with the issuer's resume data
+func saveOrder(store *state.Store, issuer CertificateIssuer, order *state.Order, status OrderStatus) {
+	order.Status = status.String()
+	if resumable, ok := issuer.(ResumableIssuer); ok {
+		orderState := resumable.OrderState(order.OrderID)
+		order.DNSRecords = orderState.DNSRecords
+		order.Data = orderState.Data
+	}
+
+	if err := store.Put(*order); err != nil {
+		log.Printf("[ERROR] Failed to save state of order %s: %v", order.OrderID, err)
+	}
+}
+
+func deleteOrder(store *state.Store, domain string) {
+	if err := store.Delete(domain); err != nil {
+		log.Printf("[ERROR] Failed to remove order state of domain %s: %v", domain, err)
+	}
+}
+
+// findDomain returns the configuration of the domain
+func findDomain(config utils.Config, domainName string) (utils.Domain, bool) {
+	for _, domain := range config.Domains {
+		if domain.DomainName == domainName {
+			return domain, true
+		}
+	}
+	return utils.Domain{}, false
+}
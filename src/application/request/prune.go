@@ -0,0 +1,60 @@
+package request
+
+import (
+	"AutoCert/src/application/dnsprovider"
+	"AutoCert/src/application/state"
+	"AutoCert/src/utils"
+	"fmt"
+	"log"
+)
+
+// PruneDNSRecords removes validation TXT records left behind in the zones of all
+// configured domains. Records of orders still tracked in the state file are kept.
+func PruneDNSRecords(config utils.Config, dryRun bool) error {
+	log.Println("[INFO] Pruning orphaned DNS validation records")
+
+	store, err := state.Open(config.State.Path)
+	if err != nil {
+		return err
+	}
+	keep := map[string]bool{}
+	for _, order := range store.List() {
+		for _, recordId := range order.DNSRecords {
+			keep[recordId] = true
+		}
+	}
+
+	var targets dnsTargets
+	pruned := map[string]bool{}
+	total := 0
+	failed := 0
+
+	for _, domain := range config.Domains {
+		target, err := targets.get(config, domain)
+		if err != nil {
+			log.Printf("[ERROR] Skipping domain %s: %v", domain.DomainName, err)
+			failed++
+			continue
+		}
+
+		// Several domains usually share a zone, only prune it once per DNS platform
+		key := domain.DNSPlatform + "/" + target.zone
+		if pruned[key] {
+			continue
+		}
+		pruned[key] = true
+
+		count, err := dnsprovider.PruneZone(target.provider, target.zone, keep, dryRun)
+		total += count
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+			failed++
+		}
+	}
+
+	log.Printf("[INFO] Pruned %d orphaned DNS validation records", total)
+	if failed > 0 {
+		return fmt.Errorf("failed to prune %d zones", failed)
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package request
+
+import (
+	"AutoCert/src/application/certstore"
+	"AutoCert/src/application/deploy"
+	"AutoCert/src/utils"
+	"fmt"
+)
+
+// RollbackCertificate reinstalls an earlier stored certificate of the domain on
+// all of its deploy platforms. An empty version selects the version stored
+// before the current one.
+func RollbackCertificate(config utils.Config, domainName, version string) error {
+	domain, ok := findDomain(config, domainName)
+	if !ok {
+		return fmt.Errorf("domain %s is not configured", domainName)
+	}
+
+	certs := certstore.New(config.Store.Root, config.Store.Retention)
+	return deploy.RollbackDomain(config, certs, domain, version)
+}
@@ -62,3 +62,44 @@ func applyTencentCloudSSLCertificate(domain string, config utils.Config) (string
 
 	return *response.Response.CertificateId, nil
 }
+
+func createTencentCloudSSLClient(config utils.Config) (*ssl.Client, error) {
+	credential := common.NewCredential(
+		config.TencentCloud.AccessKey,
+		config.TencentCloud.SecretKey,
+	)
+
+	cpf := profile.NewClientProfile()
+	cpf.HttpProfile.Endpoint = "ssl.tencentcloudapi.com"
+
+	client, err := ssl.NewClient(credential, "", cpf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SSL client: %v", err)
+	}
+	return client, nil
+}
+
+// DescribeCertificate checks the status of a certificate application
+func DescribeCertificate(config utils.Config, certificateId string) (int64, error) {
+	client, err := createTencentCloudSSLClient(config)
+	if err != nil {
+		return 0, err
+	}
+
+	request := ssl.NewDescribeCertificateRequest()
+	request.CertificateId = common.StringPtr(certificateId)
+
+	response, err := client.DescribeCertificate(request)
+	if err != nil {
+		if sdkError, ok := err.(*errors.TencentCloudSDKError); ok {
+			return 0, fmt.Errorf("API error: %s", sdkError)
+		}
+		return 0, err
+	}
+
+	if response.Response.Status == nil {
+		return 0, fmt.Errorf("status is nil")
+	}
+
+	return int64(*response.Response.Status), nil
+}
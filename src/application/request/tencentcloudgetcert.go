@@ -3,11 +3,8 @@ package request
 import (
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 
 	"archive/zip"
 	"bytes"
@@ -17,7 +14,9 @@ import (
 	ssl "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/ssl/v20191205"
 )
 
-func getTencentCloudCert(secretId, secretKey, certificateId string) ([]string, error) {
+// getTencentCloudCert downloads the nginx bundle of the certificate and
+// returns the contents of the zip keyed by file name
+func getTencentCloudCert(secretId, secretKey, certificateId string) (map[string][]byte, error) {
 	// Initialize authentication object
 	credential := common.NewCredential(secretId, secretKey)
 	log.Println("[INFO] Initialized authentication object")
@@ -53,36 +52,12 @@ func getTencentCloudCert(secretId, secretKey, certificateId string) ([]string, e
 	}
 	defer resp.Body.Close()
 
-	zipContent, err := ioutil.ReadAll(resp.Body)
+	zipContent, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Printf("[ERROR] Failed to read response body: %v", err)
 		return nil, fmt.Errorf("failed to read response body: %v", err)
 	}
-	log.Println("[INFO] Successfully downloaded certificate")
-
-	// Create target directory in the current working directory
-	currentDir, err := os.Getwd()
-	if err != nil {
-		log.Printf("[ERROR] Failed to get current working directory: %v", err)
-		return nil, fmt.Errorf("failed to get current working directory: %v", err)
-	}
-	targetDir := filepath.Join(currentDir, "gitignore", "tencentcloudssl")
-	err = os.MkdirAll(targetDir, 0755)
-	if err != nil {
-		log.Printf("[ERROR] Failed to create target directory: %v", err)
-		return nil, fmt.Errorf("failed to create target directory: %v", err)
-	}
-	log.Printf("[INFO] Created target directory: %s", targetDir)
-
-	// Save the zip file with the name from SDK response
-	zipFileName := *response.Response.DownloadFilename
-	zipFilePath := filepath.Join(targetDir, zipFileName)
-	err = ioutil.WriteFile(zipFilePath, zipContent, 0644)
-	if err != nil {
-		log.Printf("[ERROR] Failed to save zip file: %v", err)
-		return nil, fmt.Errorf("failed to save zip file: %v", err)
-	}
-	log.Printf("[INFO] Saved zip file to: %s", zipFilePath)
+	log.Printf("[INFO] Successfully downloaded certificate bundle %s", *response.Response.DownloadFilename)
 
 	// Unzip file
 	zipReader, err := zip.NewReader(bytes.NewReader(zipContent), int64(len(zipContent)))
@@ -92,14 +67,9 @@ func getTencentCloudCert(secretId, secretKey, certificateId string) ([]string, e
 	}
 	log.Println("[INFO] Successfully read zip content")
 
-	var extractedFiles []string
-
+	files := make(map[string][]byte)
 	for _, file := range zipReader.File {
-		filePath := filepath.Join(targetDir, file.Name)
-		extractedFiles = append(extractedFiles, filePath)
-
 		if file.FileInfo().IsDir() {
-			os.MkdirAll(filePath, 0755)
 			continue
 		}
 
@@ -109,27 +79,17 @@ func getTencentCloudCert(secretId, secretKey, certificateId string) ([]string, e
 			return nil, fmt.Errorf("failed to open zip file: %v", err)
 		}
 
-		targetFile, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
-		if err != nil {
-			log.Printf("[ERROR] Failed to create target file: %v", err)
-			fileReader.Close()
-			return nil, fmt.Errorf("failed to create target file: %v", err)
-		}
-
-		_, err = io.Copy(targetFile, fileReader)
+		content, err := io.ReadAll(fileReader)
+		fileReader.Close()
 		if err != nil {
-			log.Printf("[ERROR] Failed to write file content: %v", err)
-			fileReader.Close()
-			targetFile.Close()
-			return nil, fmt.Errorf("failed to write file content: %v", err)
+			log.Printf("[ERROR] Failed to read file content: %v", err)
+			return nil, fmt.Errorf("failed to read file content: %v", err)
 		}
 
-		fileReader.Close()
-		targetFile.Close()
-
-		log.Printf("[INFO] Extracted file: %s", filePath)
+		files[file.Name] = content
+		log.Printf("[INFO] Extracted file: %s", file.Name)
 	}
 
-	log.Printf("[INFO] Successfully extracted %d files", len(extractedFiles))
-	return extractedFiles, nil
+	log.Printf("[INFO] Successfully extracted %d files", len(files))
+	return files, nil
 }
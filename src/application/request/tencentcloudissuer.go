@@ -0,0 +1,100 @@
+package request
+
+import (
+	"AutoCert/src/utils"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+	ssl "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/ssl/v20191205"
+)
+
+func init() {
+	RegisterIssuer("tencentcloud", newTencentCloudIssuer)
+}
+
+// tencentCloudIssuer requests free TrustAsia certificates from Tencent Cloud SSL,
+// relying on DNS_AUTO validation for domains hosted on DNSPod
+type tencentCloudIssuer struct {
+	config utils.Config
+}
+
+func newTencentCloudIssuer(config utils.Config) CertificateIssuer {
+	return &tencentCloudIssuer{config: config}
+}
+
+func (i *tencentCloudIssuer) Apply(domain utils.Domain) (string, error) {
+	return applyTencentCloudSSLCertificate(domain.DomainName, i.config)
+}
+
+func (i *tencentCloudIssuer) Poll(domain utils.Domain, certificateId string) (OrderStatus, error) {
+	status, err := DescribeCertificate(i.config, certificateId)
+	if err != nil {
+		return OrderFailed, err
+	}
+
+	switch status {
+	case 1:
+		log.Printf("[INFO] Certificate %s has been approved", certificateId)
+		return OrderIssued, nil
+	case 0, 4:
+		return OrderPending, nil
+	default:
+		log.Printf("[ERROR] Unexpected status %d for certificate %s", status, certificateId)
+		return OrderFailed, nil
+	}
+}
+
+func (i *tencentCloudIssuer) Download(domain utils.Domain, certificateId string) (*Certificate, error) {
+	certFiles, err := getTencentCloudCert(i.config.TencentCloud.AccessKey, i.config.TencentCloud.SecretKey, certificateId)
+	if err != nil {
+		return nil, err
+	}
+
+	// The nginx bundle contains <domain>_bundle.crt (full chain) and <domain>.key
+	var bundle, key []byte
+	for name, content := range certFiles {
+		switch {
+		case strings.HasSuffix(name, "_bundle.crt"):
+			bundle = content
+		case strings.HasSuffix(name, ".key"):
+			key = content
+		}
+	}
+	if bundle == nil || key == nil {
+		return nil, fmt.Errorf("certificate bundle for %s is missing the certificate or private key", certificateId)
+	}
+
+	leaf, chain, err := splitCertificateChain(bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Certificate{
+		Domain:         domain.DomainName,
+		CertificatePEM: leaf,
+		ChainPEM:       chain,
+		PrivateKeyPEM:  key,
+	}, nil
+}
+
+func (i *tencentCloudIssuer) Cancel(domain utils.Domain, certificateId string) error {
+	client, err := createTencentCloudSSLClient(i.config)
+	if err != nil {
+		return err
+	}
+
+	request := ssl.NewCancelCertificateOrderRequest()
+	request.CertificateId = common.StringPtr(certificateId)
+
+	log.Printf("[INFO] Cancelling TencentCloud certificate order %s for domain %s", certificateId, domain.DomainName)
+	if _, err := client.CancelCertificateOrder(request); err != nil {
+		if sdkErr, ok := err.(*errors.TencentCloudSDKError); ok {
+			return fmt.Errorf("API error: %s", sdkErr)
+		}
+		return err
+	}
+	return nil
+}
@@ -1,13 +1,11 @@
 package request
 
-import "AutoCert/src/utils"
-
-func getDomainsByRequestPlatform(config utils.Config, requestPlatform string) []string {
-	var domains []string
-	for _, domain := range config.Domains {
-		if domain.RequestPlatform == requestPlatform {
-			domains = append(domains, domain.DomainName)
+// Helper function to check if a slice contains a string
+func contains(slice []string, item string) bool {
+	for _, s := range slice {
+		if s == item {
+			return true
 		}
 	}
-	return domains
+	return false
 }
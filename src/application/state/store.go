@@ -0,0 +1,150 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultPath is used when [state] path is not configured
+const DefaultPath = "gitignore/state.json"
+
+// Order is an in-flight certificate order that must survive a restart
+type Order struct {
+	Platform   string            `json:"platform"`
+	OrderID    string            `json:"order_id"`
+	Domain     string            `json:"domain"`
+	Status     string            `json:"status"`
+	DNSRecords []string          `json:"dns_records,omitempty"`
+	Data       map[string]string `json:"data,omitempty"` // provider specific resume data
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+}
+
+// Store keeps one in-flight order per domain in a JSON file
+type Store struct {
+	path string
+
+	mu     sync.Mutex
+	orders map[string]Order
+}
+
+// Open loads the state file, a missing file is an empty store
+func Open(path string) (*Store, error) {
+	if path == "" {
+		path = DefaultPath
+	}
+
+	store := &Store{path: path, orders: make(map[string]Order)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %v", err)
+	}
+
+	var orders []Order
+	if err := json.Unmarshal(data, &orders); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %v", path, err)
+	}
+	for _, order := range orders {
+		store.orders[order.Domain] = order
+	}
+	return store, nil
+}
+
+// Get returns the in-flight order of the domain
+func (s *Store) Get(domain string) (Order, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[domain]
+	return order, ok
+}
+
+// List returns all in-flight orders sorted by domain
+func (s *Store) List() []Order {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	orders := make([]Order, 0, len(s.orders))
+	for _, order := range s.orders {
+		orders = append(orders, order)
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].Domain < orders[j].Domain })
+	return orders
+}
+
+// Put stores the order, replacing any previous order of the same domain
+func (s *Store) Put(order Order) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if order.CreatedAt.IsZero() {
+		order.CreatedAt = now
+	}
+	order.UpdatedAt = now
+
+	s.orders[order.Domain] = order
+	return s.save()
+}
+
+// Delete forgets the in-flight order of the domain
+func (s *Store) Delete(domain string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.orders[domain]; !ok {
+		return nil
+	}
+	delete(s.orders, domain)
+	return s.save()
+}
+
+// save writes the state file atomically so a crash never leaves it half written
+func (s *Store) save() error {
+	orders := make([]Order, 0, len(s.orders))
+	for _, order := range s.orders {
+		orders = append(orders, order)
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].Domain < orders[j].Domain })
+
+	data, err := json.MarshalIndent(orders, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary state file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync state file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close state file: %v", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace state file: %v", err)
+	}
+	return nil
+}
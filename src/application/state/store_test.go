@@ -0,0 +1,172 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tempFiles returns the leftover temporary state files in dir
+func tempFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state", "state.json")
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.List()) != 0 {
+		t.Fatalf("missing state file opened with orders %v", store.List())
+	}
+
+	orders := []Order{
+		{Platform: "acme", OrderID: "https://ca.example/order/1", Domain: "www.example.com", Status: "pending",
+			DNSRecords: []string{"_acme-challenge.www.example.com"}, Data: map[string]string{"key": "secret"}},
+		{Platform: "aliyun", OrderID: "42", Domain: "api.example.com", Status: "processing"},
+	}
+	for _, order := range orders {
+		if err := store.Put(order); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Put(Order{Platform: "aliyun", OrderID: "43", Domain: "old.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete("old.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := reopened.List()
+	if len(list) != 2 || list[0].Domain != "api.example.com" || list[1].Domain != "www.example.com" {
+		t.Fatalf("reopened store lists %v", list)
+	}
+	order, ok := reopened.Get("www.example.com")
+	if !ok {
+		t.Fatal("order of www.example.com was not persisted")
+	}
+	if order.OrderID != orders[0].OrderID || order.Data["key"] != "secret" || len(order.DNSRecords) != 1 {
+		t.Errorf("reopened order is %+v", order)
+	}
+	if order.CreatedAt.IsZero() || order.UpdatedAt.IsZero() {
+		t.Error("order timestamps were not set")
+	}
+	if _, ok := reopened.Get("old.example.com"); ok {
+		t.Error("deleted order was persisted")
+	}
+}
+
+// The state file holds ACME order keys and must only be readable by its owner
+func TestStoreFileMode(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	if err := os.WriteFile(path, []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(Order{Platform: "acme", OrderID: "1", Domain: "www.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("state file has mode %v, want 0600", info.Mode().Perm())
+	}
+	if leftover := tempFiles(t, dir); len(leftover) != 0 {
+		t.Errorf("temporary files left behind: %v", leftover)
+	}
+}
+
+// A failed save leaves the previous state file and no temporary file behind
+func TestStoreAtomicSave(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(Order{Platform: "acme", OrderID: "1", Domain: "www.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	previous, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Renaming over a non-empty directory fails after the temporary file is written
+	blocked := filepath.Join(dir, "blocked.json")
+	if err := os.MkdirAll(filepath.Join(blocked, "entry"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	store.path = blocked
+	if err := store.Put(Order{Platform: "acme", OrderID: "2", Domain: "api.example.com"}); err == nil {
+		t.Fatal("Put succeeded although the state file could not be replaced")
+	}
+	if leftover := tempFiles(t, dir); len(leftover) != 0 {
+		t.Errorf("temporary files left behind: %v", leftover)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(previous) {
+		t.Errorf("state file changed by a failed save:\n%s", data)
+	}
+}
+
+func TestOpenRejectsCorruptFile(t *testing.T) {
+	valid := `[{"platform": "acme", "order_id": "1", "domain": "www.example.com"}]`
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"partial", valid[:len(valid)/2]},
+		{"empty", ""},
+		{"garbage", "not json"},
+		{"wrong type", `{"domain": "www.example.com"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			if err := os.WriteFile(path, []byte(test.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := Open(path)
+			if err == nil {
+				t.Fatal("Open accepted a corrupt state file")
+			}
+			if !strings.Contains(err.Error(), path) {
+				t.Errorf("error %q does not name the state file", err)
+			}
+
+			// The file is left alone so the orders can be recovered by hand
+			data, err := os.ReadFile(path)
+			if err != nil || string(data) != test.data {
+				t.Errorf("corrupt state file was modified: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// CheckIssue is a problem with the served certificate chain that is not
+// covered by the expiry of the leaf
+type CheckIssue int
+
+const (
+	// IssueHostnameMismatch means the leaf does not cover the requested server name
+	IssueHostnameMismatch CheckIssue = iota
+	// IssueSelfSigned means the leaf is signed by its own key
+	IssueSelfSigned
+	// IssueMissingIntermediate means the issuer of a served certificate is
+	// neither served nor a trusted root
+	IssueMissingIntermediate
+	// IssueChainOrder means a certificate is not followed by its issuer
+	IssueChainOrder
+	// IssueExpiredIntermediate means a served certificate other than the leaf is outside its validity
+	IssueExpiredIntermediate
+	// IssueUntrusted means the chain does not verify for any other reason
+	IssueUntrusted
+)
+
+func (i CheckIssue) String() string {
+	switch i {
+	case IssueHostnameMismatch:
+		return "hostname mismatch"
+	case IssueSelfSigned:
+		return "self-signed"
+	case IssueMissingIntermediate:
+		return "missing intermediate"
+	case IssueChainOrder:
+		return "wrong chain order"
+	case IssueExpiredIntermediate:
+		return "expired intermediate"
+	case IssueUntrusted:
+		return "untrusted"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(i))
+	}
+}
+
+// NeedsRedeploy reports whether deploying the stored certificate with its full
+// chain again fixes the issue, the other issues need a new certificate
+func (i CheckIssue) NeedsRedeploy() bool {
+	return i == IssueMissingIntermediate || i == IssueChainOrder || i == IssueExpiredIntermediate
+}
+
+// loadCheckRoots returns the system pool extended by the [check] ca_bundle
+func loadCheckRoots(caBundle string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if caBundle == "" {
+		return pool, nil
+	}
+
+	data, err := os.ReadFile(caBundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read check CA bundle: %v", err)
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in check CA bundle %s", caBundle)
+	}
+	return pool, nil
+}
+
+// ChainIssues verifies a PEM chain, leaf first, as kept in the certificate
+// store against the roots of the check, so a chain problem seen on an
+// endpoint can be told apart from one of the stored certificate itself.
+// Hostnames are not checked.
+func ChainIssues(config Config, chainPEM []byte) ([]CheckIssue, error) {
+	var chain []*x509.Certificate
+	for rest := chainPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate chain: %v", err)
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no certificate in chain")
+	}
+
+	roots, err := loadCheckRoots(config.Check.CABundle)
+	if err != nil {
+		return nil, err
+	}
+	return verifyChain(chain, roots, "", time.Now()), nil
+}
+
+// verifyChain validates the served chain, leaf first, against roots and
+// serverName and returns the issues found. Expiry of the leaf itself is left
+// to the renewal thresholds.
+func verifyChain(chain []*x509.Certificate, roots *x509.CertPool, serverName string, now time.Time) []CheckIssue {
+	issues := []CheckIssue{}
+	leaf := chain[0]
+
+	if serverName != "" {
+		if err := leaf.VerifyHostname(serverName); err != nil {
+			issues = append(issues, IssueHostnameMismatch)
+		}
+	}
+
+	if isSelfSigned(leaf) {
+		return append(issues, IssueSelfSigned)
+	}
+
+	for _, cert := range chain[1:] {
+		if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+			issues = append(issues, IssueExpiredIntermediate)
+			break
+		}
+	}
+
+	// Each certificate has to be followed by its issuer, a served issuer in
+	// another position is a wrong order while an issuer that is not served at
+	// all is missing unless it is a trusted root
+	missing := false
+	for idx, cert := range chain[:len(chain)-1] {
+		if isSelfSigned(cert) || cert.CheckSignatureFrom(chain[idx+1]) == nil {
+			continue
+		}
+		if servedIssuer(cert, chain) {
+			issues = append(issues, IssueChainOrder)
+			break
+		}
+		missing = true
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+	})
+
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalid x509.CertificateInvalidError
+	switch {
+	case err == nil:
+	case errors.As(err, &unknownAuthority):
+		// The last served certificate must chain to a root, when its issuer is not
+		// served either the intermediate is missing
+		last := chain[len(chain)-1]
+		if missing || (!isSelfSigned(last) && !servedIssuer(last, chain)) {
+			issues = append(issues, IssueMissingIntermediate)
+		} else {
+			issues = append(issues, IssueUntrusted)
+		}
+	case errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+		// Reported as the expiry of the leaf or as IssueExpiredIntermediate
+		if !now.After(leaf.NotAfter) && !containsIssue(issues, IssueExpiredIntermediate) {
+			issues = append(issues, IssueUntrusted)
+		}
+	default:
+		issues = append(issues, IssueUntrusted)
+	}
+	return issues
+}
+
+// isSelfSigned reports whether the certificate is signed by its own key.
+// CheckSignatureFrom is not used as it rejects self-signed leaves that are not CAs.
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) &&
+		cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
+
+// servedIssuer reports whether any served certificate signed cert
+func servedIssuer(cert *x509.Certificate, chain []*x509.Certificate) bool {
+	for _, candidate := range chain {
+		if candidate != cert && cert.CheckSignatureFrom(candidate) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func containsIssue(issues []CheckIssue, issue CheckIssue) bool {
+	for _, i := range issues {
+		if i == issue {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestVerifyChain(t *testing.T) {
+	now := time.Now()
+	notBefore, notAfter := now.Add(-time.Hour), now.Add(90*24*time.Hour)
+
+	root := issue(t, nil, "Test Root", true, notBefore, notAfter)
+	intermediate1 := issue(t, root, "Test Intermediate 1", true, notBefore, notAfter)
+	intermediate2 := issue(t, intermediate1, "Test Intermediate 2", true, notBefore, notAfter)
+	leaf := issue(t, intermediate2, "www.example.com", false, notBefore, notAfter, "www.example.com")
+	expired := issue(t, root, "Expired Intermediate", true, now.Add(-48*time.Hour), now.Add(-time.Hour))
+	expiredLeaf := issue(t, expired, "www.example.com", false, notBefore, notAfter, "www.example.com")
+	selfSigned := issue(t, nil, "www.example.com", false, notBefore, notAfter, "www.example.com")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+
+	tests := []struct {
+		name       string
+		chain      []*testCA
+		roots      *x509.CertPool
+		serverName string
+		want       []CheckIssue
+	}{
+		{"valid", []*testCA{leaf, intermediate2, intermediate1}, roots, "www.example.com", []CheckIssue{}},
+		{"valid with root", []*testCA{leaf, intermediate2, intermediate1, root}, roots, "www.example.com", []CheckIssue{}},
+		{"missing intermediate", []*testCA{leaf, intermediate2}, roots, "www.example.com", []CheckIssue{IssueMissingIntermediate}},
+		{"leaf only", []*testCA{leaf}, roots, "www.example.com", []CheckIssue{IssueMissingIntermediate}},
+		{"wrong order", []*testCA{leaf, intermediate1, intermediate2}, roots, "www.example.com", []CheckIssue{IssueChainOrder}},
+		{"expired intermediate", []*testCA{expiredLeaf, expired}, roots, "www.example.com", []CheckIssue{IssueExpiredIntermediate}},
+		{"self-signed", []*testCA{selfSigned}, roots, "www.example.com", []CheckIssue{IssueSelfSigned}},
+		{"untrusted", []*testCA{leaf, intermediate2, intermediate1, root}, x509.NewCertPool(), "www.example.com", []CheckIssue{IssueUntrusted}},
+		{"hostname mismatch", []*testCA{leaf, intermediate2, intermediate1}, roots, "api.example.com", []CheckIssue{IssueHostnameMismatch}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chain := make([]*x509.Certificate, len(test.chain))
+			for idx, ca := range test.chain {
+				chain[idx] = ca.cert
+			}
+			if got := verifyChain(chain, test.roots, test.serverName, now); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("verifyChain = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestChainIssuesOfStoredChain(t *testing.T) {
+	now := time.Now()
+	root := issue(t, nil, "Test Root", true, now.Add(-48*time.Hour), now.Add(90*24*time.Hour))
+	expired := issue(t, root, "Expired Intermediate", true, now.Add(-48*time.Hour), now.Add(-time.Hour))
+	leaf := issue(t, expired, "www.example.com", false, now.Add(-time.Hour), now.Add(90*24*time.Hour), "www.example.com")
+
+	var chainPEM []byte
+	for _, ca := range []*testCA{leaf, expired} {
+		chainPEM = append(chainPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})...)
+	}
+
+	issues, err := ChainIssues(Config{}, chainPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) == 0 || issues[0] != IssueExpiredIntermediate {
+		t.Fatalf("ChainIssues = %v, want the expired intermediate", issues)
+	}
+
+	if _, err := ChainIssues(Config{}, []byte("not a certificate")); err == nil {
+		t.Error("ChainIssues accepted a chain without certificates")
+	}
+}
@@ -1,67 +1,304 @@
 package utils
 
 import (
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"fmt"
 	"log"
+	"net"
+	"strings"
+	"sync"
 	"time"
 )
 
-func CheckSSLCertificates(config Config) ([]string, []string, []string) {
-	log.Println("[INFO] Starting SSL certificate check for all domains")
-	expiringDomains := []string{}
-	expiredDomains := []string{}
-	errorDomains := []string{}
+const (
+	// tlsDialTimeout bounds connecting to a TLS endpoint including the handshake
+	tlsDialTimeout = 30 * time.Second
 
-	for _, domain := range config.Domains {
-		log.Printf("[INFO] Checking certificate for domain: %s", domain.DomainName)
-		domainName, expirationDate, err := checkCertificateExpTime(domain.DomainName)
-		if err != nil {
-			log.Printf("[ERROR] Failed to check certificate for domain %s: %v", domainName, err)
-			errorDomains = append(errorDomains, domainName)
-			continue
+	defaultCheckConcurrency = 16
+	defaultCheckTimeout     = 10 * time.Second
+	defaultCheckPort        = "443"
+)
+
+// CheckStatus classifies the certificate served for a domain
+type CheckStatus int
+
+const (
+	CheckValid CheckStatus = iota
+	// CheckWarning is within warn_before but not yet due for renewal
+	CheckWarning
+	// CheckExpiring is within renew_before
+	CheckExpiring
+	CheckExpired
+	// CheckInvalid is within its validity but the chain or hostname fails verification, see Issues
+	CheckInvalid
+	// CheckError means the certificate could not be retrieved
+	CheckError
+)
+
+func (s CheckStatus) String() string {
+	switch s {
+	case CheckValid:
+		return "valid"
+	case CheckWarning:
+		return "warning"
+	case CheckExpiring:
+		return "expiring"
+	case CheckExpired:
+		return "expired"
+	case CheckInvalid:
+		return "invalid"
+	case CheckError:
+		return "error"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// NeedsRenewal reports whether a new certificate should be requested. A
+// CheckError is a connection or DNS failure of a single address that a new
+// certificate does not fix, it is only reported.
+func (s CheckStatus) NeedsRenewal() bool {
+	return s == CheckExpiring || s == CheckExpired
+}
+
+// CheckResult is the certificate served for a domain at one endpoint. The
+// certificate fields are empty when Status is CheckError.
+type CheckResult struct {
+	Domain string
+	// Endpoint is the dialed host:port, ServerName the SNI sent to it
+	Endpoint     string
+	ServerName   string
+	NotBefore    time.Time
+	NotAfter     time.Time
+	Issuer       string
+	DNSNames     []string
+	SerialNumber string
+	// Fingerprint is the hex SHA-256 of the leaf certificate, as in the certificate store
+	Fingerprint string
+	// ChainLength counts the served certificates including the leaf
+	ChainLength int
+	Issues      []CheckIssue
+	Status      CheckStatus
+	Err         error
+}
+
+// NeedsRenewal reports whether a new certificate should be requested because
+// of the status or an issue a redeployment cannot fix
+func (r CheckResult) NeedsRenewal() bool {
+	if r.Status.NeedsRenewal() {
+		return true
+	}
+	for _, issue := range r.Issues {
+		if !issue.NeedsRedeploy() {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsRedeploy reports whether the endpoint serves a broken chain that
+// deploying the stored certificate again fixes
+func (r CheckResult) NeedsRedeploy() bool {
+	for _, issue := range r.Issues {
+		if issue.NeedsRedeploy() {
+			return true
 		}
+	}
+	return false
+}
+
+// CheckSSLCertificates checks the served certificate of every domain at each
+// of its endpoints with [check] concurrency workers. The results keep the
+// order of the domains in the configuration and of their endpoints regardless
+// of which check finishes first.
+func CheckSSLCertificates(config Config) []CheckResult {
+	log.Println("[INFO] Starting SSL certificate check for all domains")
 
-		timeUntilExpiration := time.Until(expirationDate)
-		if timeUntilExpiration <= 0 {
-			log.Printf("[WARN] Certificate for domain %s has expired", domainName)
-			expiredDomains = append(expiredDomains, domainName)
-		} else if timeUntilExpiration.Hours() <= 72 {
-			log.Printf("[WARN] Certificate for domain %s is expiring soon (within 72 hours)", domainName)
-			expiringDomains = append(expiringDomains, domainName)
-		} else {
-			log.Printf("[INFO] Certificate for domain %s is valid", domainName)
+	concurrency := config.Check.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultCheckConcurrency
+	}
+	if concurrency > len(config.Domains) {
+		concurrency = len(config.Domains)
+	}
+	timeout := config.Check.Timeout
+	if timeout <= 0 {
+		timeout = defaultCheckTimeout
+	}
+
+	roots, err := loadCheckRoots(config.Check.CABundle)
+	if err != nil {
+		log.Printf("[ERROR] %v, verifying against the system roots only", err)
+		roots, _ = loadCheckRoots("")
+	}
+
+	domainResults := make([][]CheckResult, len(config.Domains))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				log.Printf("[INFO] Checking certificate for domain: %s", config.Domains[idx].DomainName)
+				domainResults[idx] = checkDomain(config.Domains[idx], roots, timeout)
+			}
+		}()
+	}
+	for idx := range config.Domains {
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+
+	results := []CheckResult{}
+	for idx, domain := range config.Domains {
+		for _, result := range domainResults[idx] {
+			classify(config, domain, &result)
+			results = append(results, result)
 		}
 	}
 
 	log.Println("[INFO] Completed SSL certificate check for all domains")
-	return expiringDomains, expiredDomains, errorDomains
+	return results
 }
 
-func checkCertificateExpTime(domain string) (string, time.Time, error) {
-	log.Printf("[INFO] Checking certificate expiration time for domain: %s", domain)
-	conf := &tls.Config{
-		InsecureSkipVerify: true,
+// classify sets the status of a result from the thresholds of the domain
+func classify(config Config, domain Domain, result *CheckResult) {
+	if result.Err != nil {
+		result.Status = CheckError
+		log.Printf("[ERROR] Failed to check certificate for domain %s at %s: %v", result.Domain, result.Endpoint, result.Err)
+		return
 	}
-	conn, err := tls.Dial("tcp", domain+":443", conf)
-	if err != nil {
-		return domain, time.Time{}, fmt.Errorf("connection failed: %v", err)
+
+	if len(result.Issues) > 0 {
+		log.Printf("[WARN] Certificate for domain %s at %s has issues: %v", result.Domain, result.Endpoint, result.Issues)
 	}
-	defer conn.Close()
 
-	state := conn.ConnectionState()
-	if len(state.PeerCertificates) == 0 {
-		return domain, time.Time{}, fmt.Errorf("SSL certificate not configured")
+	renewBefore := config.RenewBefore(domain)
+	warnBefore := config.WarnBefore(domain)
+	timeUntilExpiration := time.Until(result.NotAfter)
+	if timeUntilExpiration <= 0 {
+		result.Status = CheckExpired
+		log.Printf("[WARN] Certificate for domain %s at %s has expired", result.Domain, result.Endpoint)
+	} else if renewBefore.Reached(result.NotBefore, result.NotAfter) {
+		result.Status = CheckExpiring
+		log.Printf("[WARN] Certificate for domain %s at %s is due for renewal (renew_before %s)", result.Domain, result.Endpoint, renewBefore)
+	} else if len(result.Issues) > 0 {
+		result.Status = CheckInvalid
+	} else if !warnBefore.IsZero() && warnBefore.Reached(result.NotBefore, result.NotAfter) {
+		// Notification only, the certificate is not renewed yet
+		result.Status = CheckWarning
+		log.Printf("[WARN] Certificate for domain %s at %s expires within warn_before %s", result.Domain, result.Endpoint, warnBefore)
+	} else {
+		result.Status = CheckValid
+		log.Printf("[INFO] Certificate for domain %s at %s is valid", result.Domain, result.Endpoint)
 	}
+}
 
-	cert := state.PeerCertificates[0]
-	expirationDate := cert.NotAfter
+// CheckEndpoint is an address the certificate of a domain is checked at
+type CheckEndpoint struct {
+	// Address is the dialed host:port, ServerName the SNI sent to it
+	Address    string
+	ServerName string
+	// Err is set when the addresses of an all_ips endpoint could not be resolved
+	Err error
+}
 
-	// Check certificate chain
-	if len(state.PeerCertificates) == 1 {
-		log.Printf("[WARN] SSL certificate chain for domain %s is incomplete (single certificate only)", domain)
+// CheckEndpoints returns the addresses the certificate of the domain is
+// served at, see Domain.Check. Each all_ips lookup is bounded by timeout.
+func (d Domain) CheckEndpoints(timeout time.Duration) []CheckEndpoint {
+	settings := d.Check
+	hosts := settings.Endpoints
+	if len(hosts) == 0 {
+		hosts = []string{d.DomainName}
 	}
 
+	endpoints := []CheckEndpoint{}
+	for _, endpoint := range hosts {
+		host, port, err := net.SplitHostPort(endpoint)
+		if err != nil {
+			host, port = endpoint, defaultCheckPort
+		}
+
+		// The domain is asked for unless it is a wildcard the endpoint name has to fill in
+		serverName := settings.ServerName
+		if serverName == "" {
+			serverName = d.DomainName
+			if strings.HasPrefix(serverName, "*.") {
+				serverName = host
+			}
+		}
+
+		addresses := settings.IPs
+		if len(addresses) == 0 && settings.AllIPs {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+			cancel()
+			if err != nil {
+				endpoints = append(endpoints, CheckEndpoint{
+					Address:    net.JoinHostPort(host, port),
+					ServerName: serverName,
+					Err:        fmt.Errorf("failed to resolve %s: %v", host, err),
+				})
+				continue
+			}
+			for _, ip := range ips {
+				addresses = append(addresses, ip.String())
+			}
+		}
+		if len(addresses) == 0 {
+			addresses = []string{host}
+		}
+
+		for _, address := range addresses {
+			endpoints = append(endpoints, CheckEndpoint{Address: net.JoinHostPort(address, port), ServerName: serverName})
+		}
+	}
+	return endpoints
+}
+
+// checkDomain checks every endpoint of the domain. Each DNS lookup and TLS
+// connection is bounded by timeout.
+func checkDomain(domain Domain, roots *x509.CertPool, timeout time.Duration) []CheckResult {
+	results := []CheckResult{}
+	for _, endpoint := range domain.CheckEndpoints(timeout) {
+		if endpoint.Err != nil {
+			results = append(results, CheckResult{
+				Domain:     domain.DomainName,
+				Endpoint:   endpoint.Address,
+				ServerName: endpoint.ServerName,
+				Err:        endpoint.Err,
+			})
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		results = append(results, checkCertificateExpTime(ctx, domain.DomainName, endpoint.Address, endpoint.ServerName, roots))
+		cancel()
+	}
+	return results
+}
+
+// checkCertificateExpTime fetches the certificate served for the domain at
+// address, verifies the chain against roots and fills in everything of the
+// result except Status
+func checkCertificateExpTime(ctx context.Context, domain, address, serverName string, roots *x509.CertPool) CheckResult {
+	log.Printf("[INFO] Checking certificate expiration time for domain %s at %s (SNI %s)", domain, address, serverName)
+	result := CheckResult{Domain: domain, Endpoint: address, ServerName: serverName}
+	peerCertificates, err := FetchPeerCertificatesContext(ctx, address, serverName)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	cert := peerCertificates[0]
+	expirationDate := cert.NotAfter
+
 	// Calculate days, hours, minutes and seconds
 	days := int(time.Until(expirationDate).Hours()) / 24
 	hours := int(time.Until(expirationDate).Hours()) % 24
@@ -75,10 +312,50 @@ func checkCertificateExpTime(domain string) (string, time.Time, error) {
 	gmt8, _ := time.LoadLocation("Asia/Shanghai")
 	gmt8Time := expirationDate.In(gmt8)
 
-	log.Printf("[INFO] Domain: %s", domain)
+	log.Printf("[INFO] Domain: %s, endpoint: %s", domain, address)
 	log.Printf("[INFO] Certificate expiration time (local timezone): %s", localTime.Format("2006-01-02 15:04:05 MST"))
 	log.Printf("[INFO] Certificate expiration time (GMT+8): %s", gmt8Time.Format("2006-01-02 15:04:05 MST"))
 	log.Printf("[INFO] Time until expiration: %d days %d hours %d minutes %d seconds", days, hours, minutes, seconds)
 
-	return domain, expirationDate, nil
+	fingerprint := sha256.Sum256(cert.Raw)
+	result.NotBefore = cert.NotBefore
+	result.NotAfter = cert.NotAfter
+	result.Issuer = cert.Issuer.String()
+	result.DNSNames = cert.DNSNames
+	result.SerialNumber = cert.SerialNumber.Text(16)
+	result.Fingerprint = hex.EncodeToString(fingerprint[:])
+	result.ChainLength = len(peerCertificates)
+	result.Issues = verifyChain(peerCertificates, roots, serverName, time.Now())
+	return result
+}
+
+// FetchPeerCertificates connects to address over TLS and returns the served
+// certificate chain, leaf first. serverName is sent as SNI and defaults to the
+// host of address.
+func FetchPeerCertificates(address, serverName string) ([]*x509.Certificate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tlsDialTimeout)
+	defer cancel()
+	return FetchPeerCertificatesContext(ctx, address, serverName)
+}
+
+// FetchPeerCertificatesContext is FetchPeerCertificates with the connection
+// and handshake bounded by ctx
+func FetchPeerCertificatesContext(ctx context.Context, address, serverName string) ([]*x509.Certificate, error) {
+	dialer := &tls.Dialer{
+		Config: &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: true,
+		},
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("connection failed: %v", err)
+	}
+	defer conn.Close()
+
+	state := conn.(*tls.Conn).ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return nil, fmt.Errorf("SSL certificate not configured")
+	}
+	return state.PeerCertificates, nil
 }
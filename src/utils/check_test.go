@@ -0,0 +1,356 @@
+package utils
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testCA issues the certificates of a test chain
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// issue creates a certificate signed by parent, self-signed when parent is nil
+func issue(t *testing.T, parent *testCA, name string, isCA bool, notBefore, notAfter time.Time, dnsNames ...string) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              dnsNames,
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	if !isCA {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// testTLSServer serves a leaf certificate and records the SNI of each handshake
+type testTLSServer struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	serverNames []string
+}
+
+// serveTLS starts a TLS server for leaf on 127.0.0.1, handshake runs at the
+// start of every handshake when not nil
+func serveTLS(t *testing.T, leaf *testCA, handshake func()) *testTLSServer {
+	t.Helper()
+
+	server := &testTLSServer{Server: httptest.NewUnstartedServer(nil)}
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{leaf.cert.Raw}, PrivateKey: leaf.key}},
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			server.mu.Lock()
+			server.serverNames = append(server.serverNames, hello.ServerName)
+			server.mu.Unlock()
+			if handshake != nil {
+				handshake()
+			}
+			return nil, nil
+		},
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// address returns the host:port the server listens on
+func (s *testTLSServer) address() string {
+	return s.Listener.Addr().String()
+}
+
+// requested returns the SNI of every handshake so far
+func (s *testTLSServer) requested() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.serverNames...)
+}
+
+// writeCABundle writes the certificate of ca to a PEM bundle for [check] ca_bundle
+func writeCABundle(t *testing.T, ca *testCA) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// The workers finish in reverse order, the results still follow the configuration
+func TestCheckSSLCertificatesWorkerPool(t *testing.T) {
+	now := time.Now()
+	root := issue(t, nil, "Test Root", true, now.Add(-time.Hour), now.Add(365*24*time.Hour))
+
+	const concurrency = 2
+	var (
+		mu                  sync.Mutex
+		inFlight, maxFlight int
+	)
+	var config Config
+	config.Check.Concurrency = concurrency
+	config.Check.Timeout = 5 * time.Second
+	config.Check.CABundle = writeCABundle(t, root)
+
+	var servers []*testTLSServer
+	for i := 0; i < 6; i++ {
+		name := string(rune('a'+i)) + ".example.com"
+		leaf := issue(t, root, name, false, now.Add(-time.Hour), now.Add(90*24*time.Hour), name)
+		delay := time.Duration(6-i) * 30 * time.Millisecond
+		server := serveTLS(t, leaf, func() {
+			mu.Lock()
+			inFlight++
+			if inFlight > maxFlight {
+				maxFlight = inFlight
+			}
+			mu.Unlock()
+
+			time.Sleep(delay)
+
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		})
+		servers = append(servers, server)
+
+		domain := Domain{DomainName: name}
+		domain.Check.Endpoints = []string{server.address()}
+		config.Domains = append(config.Domains, domain)
+	}
+
+	results := CheckSSLCertificates(config)
+	if len(results) != len(config.Domains) {
+		t.Fatalf("got %d results for %d domains", len(results), len(config.Domains))
+	}
+	for i, result := range results {
+		domain := config.Domains[i].DomainName
+		if result.Domain != domain || result.Endpoint != servers[i].address() {
+			t.Errorf("result %d is for %s at %s, want %s at %s", i, result.Domain, result.Endpoint, domain, servers[i].address())
+		}
+		if result.Status != CheckValid || len(result.DNSNames) != 1 || result.DNSNames[0] != domain {
+			t.Errorf("result %d has status %s and names %v: %v", i, result.Status, result.DNSNames, result.Err)
+		}
+		if requested := servers[i].requested(); len(requested) != 1 || requested[0] != domain {
+			t.Errorf("server of %s was asked for %v", domain, requested)
+		}
+	}
+
+	if maxFlight > concurrency {
+		t.Errorf("%d checks ran at once with concurrency %d", maxFlight, concurrency)
+	}
+	if maxFlight < concurrency {
+		t.Errorf("at most %d checks ran at once with concurrency %d", maxFlight, concurrency)
+	}
+}
+
+func TestCheckErrorDoesNotNeedRenewal(t *testing.T) {
+	// A closed port stands in for an address the checker cannot reach
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	var config Config
+	config.Check.Timeout = time.Second
+	domain := Domain{DomainName: "www.example.com"}
+	domain.Check.Endpoints = []string{address}
+	config.Domains = []Domain{domain}
+
+	results := CheckSSLCertificates(config)
+	if len(results) != 1 || results[0].Status != CheckError {
+		t.Fatalf("results = %+v, want a single CheckError", results)
+	}
+	if results[0].NeedsRenewal() {
+		t.Error("an unreachable endpoint triggers a renewal")
+	}
+}
+
+func TestCheckStatusNeedsRenewal(t *testing.T) {
+	for status, want := range map[CheckStatus]bool{
+		CheckValid:    false,
+		CheckWarning:  false,
+		CheckExpiring: true,
+		CheckExpired:  true,
+		CheckInvalid:  false,
+		CheckError:    false,
+	} {
+		if got := status.NeedsRenewal(); got != want {
+			t.Errorf("%s.NeedsRenewal() = %v, want %v", status, got, want)
+		}
+	}
+}
+
+func TestCheckDomainEndpoints(t *testing.T) {
+	now := time.Now()
+	root := issue(t, nil, "Test Root", true, now.Add(-time.Hour), now.Add(365*24*time.Hour))
+	leaf := issue(t, root, "example.com", false, now.Add(-time.Hour), now.Add(90*24*time.Hour),
+		"example.com", "www.example.com", "*.example.com")
+	roots, err := loadCheckRoots(writeCABundle(t, root))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	localhost, err := net.DefaultResolver.LookupIPAddr(context.Background(), "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// want lists the dialed host and the SNI of each result, the port is the server's
+	type endpoint struct{ host, serverName string }
+	tests := []struct {
+		name   string
+		domain string
+		check  func(port string) (endpoints []string, serverName string, ips []string, allIPs bool)
+		want   []endpoint
+	}{
+		{
+			name:   "server name override",
+			domain: "example.com",
+			check: func(port string) ([]string, string, []string, bool) {
+				return []string{net.JoinHostPort("127.0.0.1", port)}, "www.example.com", nil, false
+			},
+			want: []endpoint{{"127.0.0.1", "www.example.com"}},
+		},
+		{
+			name:   "domain as server name",
+			domain: "example.com",
+			check: func(port string) ([]string, string, []string, bool) {
+				return []string{net.JoinHostPort("127.0.0.1", port)}, "", nil, false
+			},
+			want: []endpoint{{"127.0.0.1", "example.com"}},
+		},
+		{
+			name:   "ips pin every endpoint",
+			domain: "example.com",
+			check: func(port string) ([]string, string, []string, bool) {
+				return []string{net.JoinHostPort("www.example.com", port), net.JoinHostPort("origin.example.net", port)}, "", []string{"127.0.0.1"}, true
+			},
+			want: []endpoint{{"127.0.0.1", "example.com"}, {"127.0.0.1", "example.com"}},
+		},
+		{
+			name:   "wildcard filled in by the endpoint",
+			domain: "*.example.com",
+			check: func(port string) ([]string, string, []string, bool) {
+				return []string{net.JoinHostPort("api.example.com", port)}, "", []string{"127.0.0.1"}, false
+			},
+			want: []endpoint{{"127.0.0.1", "api.example.com"}},
+		},
+		{
+			name:   "all ips",
+			domain: "example.com",
+			check: func(port string) ([]string, string, []string, bool) {
+				return []string{net.JoinHostPort("localhost", port)}, "www.example.com", nil, true
+			},
+			want: func() []endpoint {
+				var want []endpoint
+				for _, addr := range localhost {
+					want = append(want, endpoint{addr.String(), "www.example.com"})
+				}
+				return want
+			}(),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := serveTLS(t, leaf, nil)
+			_, port, err := net.SplitHostPort(server.address())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			domain := Domain{DomainName: test.domain}
+			domain.Check.Endpoints, domain.Check.ServerName, domain.Check.IPs, domain.Check.AllIPs = test.check(port)
+			results := checkDomain(domain, roots, 5*time.Second)
+			if len(results) != len(test.want) {
+				t.Fatalf("got %d results, want %d: %+v", len(results), len(test.want), results)
+			}
+
+			var served []string
+			for i, result := range results {
+				address := net.JoinHostPort(test.want[i].host, port)
+				if result.Endpoint != address || result.ServerName != test.want[i].serverName {
+					t.Errorf("result %d dialed %s with SNI %s, want %s with SNI %s", i, result.Endpoint, result.ServerName, address, test.want[i].serverName)
+				}
+				// Only the IPv4 loopback is served, other localhost addresses may refuse
+				if test.want[i].host != "127.0.0.1" {
+					continue
+				}
+				if result.Err != nil || len(result.Issues) != 0 {
+					t.Errorf("result %d failed: %v %v", i, result.Err, result.Issues)
+				}
+				served = append(served, result.ServerName)
+			}
+
+			if requested := server.requested(); !slices.Equal(requested, served) {
+				t.Errorf("server saw SNI %v, want %v", requested, served)
+			}
+		})
+	}
+}
+
+func TestCheckDomainResolveFailure(t *testing.T) {
+	domain := Domain{DomainName: "example.com"}
+	domain.Check.Endpoints = []string{"missing.invalid:8443"}
+	domain.Check.AllIPs = true
+
+	results := checkDomain(domain, x509.NewCertPool(), 2*time.Second)
+	if len(results) != 1 || results[0].Err == nil {
+		t.Fatalf("results = %+v, want a single lookup error", results)
+	}
+	if results[0].Endpoint != "missing.invalid:8443" || results[0].ServerName != "example.com" {
+		t.Errorf("lookup error reported for %s with SNI %s", results[0].Endpoint, results[0].ServerName)
+	}
+}
+
+func TestCheckEndpointsDefault(t *testing.T) {
+	endpoints := Domain{DomainName: "www.example.com"}.CheckEndpoints(time.Second)
+	if len(endpoints) != 1 || endpoints[0].Address != "www.example.com:443" || endpoints[0].ServerName != "www.example.com" {
+		t.Errorf("default endpoints are %+v, want www.example.com:443", endpoints)
+	}
+}
@@ -3,14 +3,20 @@ package utils
 import (
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
 
 type Config struct {
 	Aliyun struct {
-		AccessKey string `toml:"access_key"`
-		SecretKey string `toml:"secret_key"`
+		AccessKey   string `toml:"access_key"`
+		SecretKey   string `toml:"secret_key"`
+		DNSEndpoint string `toml:"dns_endpoint"`
+		// CASRegion is the region of the CAS certificate service, cn-hangzhou
+		// on the China site and ap-southeast-1 on the international site
+		CASRegion string `toml:"cas_region"`
 	} `toml:"aliyun"`
 
 	TencentCloud struct {
@@ -24,14 +30,146 @@ type Config struct {
 		Endpoint  string `toml:"endpoint"`
 	} `toml:"akilight"`
 
+	Baishan struct {
+		Token    string `toml:"token"`
+		Endpoint string `toml:"endpoint"`
+	} `toml:"baishan"`
+
+	ACME struct {
+		DirectoryURL string `toml:"directory_url"`
+		Email        string `toml:"email"`
+		AccountKey   string `toml:"account_key"`
+		CABundle     string `toml:"ca_bundle"`
+		EABKeyID     string `toml:"eab_kid"`
+		EABHMACKey   string `toml:"eab_hmac_key"`
+	} `toml:"acme"`
+
+	DNS struct {
+		Resolvers           []string      `toml:"resolvers"`
+		PropagationTimeout  time.Duration `toml:"propagation_timeout"`
+		PropagationInterval time.Duration `toml:"propagation_interval"`
+	} `toml:"dns"`
+
+	// Renewal holds the default thresholds, each domain can override them
+	Renewal struct {
+		RenewBefore Threshold `toml:"renew_before"`
+		WarnBefore  Threshold `toml:"warn_before"`
+	} `toml:"renewal"`
+
+	// Check configures the certificate check of all domains
+	Check struct {
+		Concurrency int           `toml:"concurrency"`
+		Timeout     time.Duration `toml:"timeout"`
+		// CABundle adds PEM roots to the system pool the served chains are verified against
+		CABundle string `toml:"ca_bundle"`
+	} `toml:"check"`
+
+	// Deploy controls how long deployments are verified against the served certificate
+	Deploy struct {
+		VerifyTimeout  time.Duration `toml:"verify_timeout"`
+		VerifyInterval time.Duration `toml:"verify_interval"`
+	} `toml:"deploy"`
+
+	State struct {
+		Path string `toml:"path"`
+	} `toml:"state"`
+
+	Store struct {
+		Root      string `toml:"root"`
+		Retention int    `toml:"retention"`
+	} `toml:"store"`
+
 	Domains []Domain `toml:"domains"`
 }
 
 type Domain struct {
-	DomainName      string `toml:"domain_name"`
-	BaseDomain      string `toml:"base_domain"`
-	RequestPlatform string `toml:"request_platform"`
-	DeployPlatform  string `toml:"deploy_platform"`
+	DomainName      string    `toml:"domain_name"`
+	AltNames        []string  `toml:"alt_names"`
+	BaseDomain      string    `toml:"base_domain"`
+	RequestPlatform string    `toml:"request_platform"`
+	DNSPlatform     string    `toml:"dns_platform"`
+	DeployPlatform  string    `toml:"deploy_platform"`
+	RenewBefore     Threshold `toml:"renew_before"`
+	WarnBefore      Threshold `toml:"warn_before"`
+
+	// Check selects where the certificate of the domain is checked and, after
+	// a deployment, verified. Endpoints default to the domain on port 443, IPs
+	// pins every endpoint to the given addresses and AllIPs dials every A/AAAA
+	// record instead of a single one.
+	Check struct {
+		Endpoints  []string `toml:"endpoints"`
+		ServerName string   `toml:"server_name"`
+		IPs        []string `toml:"ips"`
+		AllIPs     bool     `toml:"all_ips"`
+	} `toml:"check"`
+
+	// Aliyun selects the resources updated by the aliyun deployer
+	Aliyun struct {
+		ResourceTypes []string `toml:"resource_types"`
+		Regions       []string `toml:"regions"`
+	} `toml:"aliyun"`
+
+	// File configures where the file deployer writes the certificate and how the server is reloaded
+	File struct {
+		CertificateFiles
+		Owner           string `toml:"owner"`
+		ValidateCommand string `toml:"validate_command"`
+		ReloadCommand   string `toml:"reload_command"`
+	} `toml:"file"`
+
+	// SSH configures the hosts the ssh deployer uploads the certificate to
+	SSH struct {
+		CertificateFiles
+		Hosts       []string `toml:"hosts"`
+		User        string   `toml:"user"`
+		PrivateKey  string   `toml:"private_key"`
+		Passphrase  string   `toml:"passphrase"`
+		KnownHosts  string   `toml:"known_hosts"`
+		PreCommand  string   `toml:"pre_command"`
+		PostCommand string   `toml:"post_command"`
+		VerifyPort  int      `toml:"verify_port"`
+	} `toml:"ssh"`
+
+	// Kubernetes configures the TLS Secret written by the kubernetes deployer
+	Kubernetes struct {
+		Kubeconfig         string   `toml:"kubeconfig"`
+		Context            string   `toml:"context"`
+		Namespace          string   `toml:"namespace"`
+		SecretName         string   `toml:"secret_name"`
+		RestartDeployments []string `toml:"restart_deployments"`
+	} `toml:"kubernetes"`
+
+	// TencentCloud selects the resources updated by the tencentcloud deployer
+	TencentCloud struct {
+		ResourceTypes []string `toml:"resource_types"`
+		Regions       []string `toml:"regions"`
+		InstanceIds   []string `toml:"instance_ids"`
+	} `toml:"tencentcloud"`
+}
+
+// CertificateFiles are the paths the file and ssh deployers write the
+// certificate to, only the configured ones are written. CombinedPath holds
+// the full chain followed by the key as haproxy expects.
+type CertificateFiles struct {
+	CertPath      string `toml:"cert_path"`
+	ChainPath     string `toml:"chain_path"`
+	FullChainPath string `toml:"fullchain_path"`
+	KeyPath       string `toml:"key_path"`
+	CombinedPath  string `toml:"combined_path"`
+	Mode          string `toml:"mode"`
+	KeyMode       string `toml:"key_mode"`
+}
+
+// DeployPlatforms returns the deploy platforms of the domain, deploy_platform
+// accepts a comma separated list such as "aliyun,file"
+func (d Domain) DeployPlatforms() []string {
+	var platforms []string
+	for _, platform := range strings.Split(d.DeployPlatform, ",") {
+		if platform = strings.TrimSpace(platform); platform != "" {
+			platforms = append(platforms, platform)
+		}
+	}
+	return platforms
 }
 
 func InitializationConfig() Config {
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultRenewBefore is used when renew_before is configured neither globally nor for the domain
+var DefaultRenewBefore = Threshold{Duration: 72 * time.Hour}
+
+// Threshold is how long before expiry something happens, either an absolute
+// duration ("72h", "30d") or a percentage of the certificate lifetime ("33%")
+type Threshold struct {
+	Duration time.Duration
+	Percent  float64
+}
+
+// UnmarshalText parses a threshold from the TOML configuration
+func (t *Threshold) UnmarshalText(text []byte) error {
+	value := strings.TrimSpace(string(text))
+	if value == "" {
+		*t = Threshold{}
+		return nil
+	}
+
+	if strings.HasSuffix(value, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return fmt.Errorf("invalid percentage %q, expected a value in (0%%, 100%%]", value)
+		}
+		*t = Threshold{Percent: percent}
+		return nil
+	}
+
+	// time.ParseDuration has no unit for days
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(value, "d"), 64)
+		if err != nil || days <= 0 {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		*t = Threshold{Duration: time.Duration(days * float64(24*time.Hour))}
+		return nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return fmt.Errorf("invalid duration %q", value)
+	}
+	*t = Threshold{Duration: duration}
+	return nil
+}
+
+func (t Threshold) String() string {
+	if t.Percent > 0 {
+		return strconv.FormatFloat(t.Percent, 'f', -1, 64) + "%"
+	}
+	return t.Duration.String()
+}
+
+// IsZero reports whether the threshold is not configured
+func (t Threshold) IsZero() bool {
+	return t.Duration == 0 && t.Percent == 0
+}
+
+// Before returns the remaining lifetime at which the threshold is reached for
+// a certificate valid from notBefore to notAfter
+func (t Threshold) Before(notBefore, notAfter time.Time) time.Duration {
+	if t.Percent > 0 {
+		lifetime := notAfter.Sub(notBefore)
+		return time.Duration(float64(lifetime) * t.Percent / 100)
+	}
+	return t.Duration
+}
+
+// Reached reports whether less than the threshold of the certificate lifetime remains
+func (t Threshold) Reached(notBefore, notAfter time.Time) bool {
+	return time.Until(notAfter) <= t.Before(notBefore, notAfter)
+}
+
+// RenewBefore returns the renew_before of the domain, falling back to the
+// global [renewal] setting and then DefaultRenewBefore
+func (config Config) RenewBefore(domain Domain) Threshold {
+	switch {
+	case !domain.RenewBefore.IsZero():
+		return domain.RenewBefore
+	case !config.Renewal.RenewBefore.IsZero():
+		return config.Renewal.RenewBefore
+	default:
+		return DefaultRenewBefore
+	}
+}
+
+// WarnBefore returns the warn_before of the domain, falling back to the global
+// [renewal] setting. A zero threshold disables the warning.
+func (config Config) WarnBefore(domain Domain) Threshold {
+	if !domain.WarnBefore.IsZero() {
+		return domain.WarnBefore
+	}
+	return config.Renewal.WarnBefore
+}
@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThresholdUnmarshalText(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    Threshold
+		wantErr bool
+	}{
+		{text: "30d", want: Threshold{Duration: 30 * 24 * time.Hour}},
+		{text: "1.5d", want: Threshold{Duration: 36 * time.Hour}},
+		{text: "720h", want: Threshold{Duration: 720 * time.Hour}},
+		{text: " 72h ", want: Threshold{Duration: 72 * time.Hour}},
+		{text: "33%", want: Threshold{Percent: 33}},
+		{text: "100%", want: Threshold{Percent: 100}},
+		{text: "12.5%", want: Threshold{Percent: 12.5}},
+		{text: "", want: Threshold{}},
+		{text: "0%", wantErr: true},
+		{text: "150%", wantErr: true},
+		{text: "-5%", wantErr: true},
+		{text: "-1d", wantErr: true},
+		{text: "0d", wantErr: true},
+		{text: "-72h", wantErr: true},
+		{text: "0s", wantErr: true},
+		{text: "30", wantErr: true},
+		{text: "d", wantErr: true},
+		{text: "%", wantErr: true},
+		{text: "thirty days", wantErr: true},
+	}
+	for _, test := range tests {
+		// A previous value must not leak into the result
+		threshold := Threshold{Duration: time.Minute, Percent: 1}
+		err := threshold.UnmarshalText([]byte(test.text))
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%q parsed as %v, want an error", test.text, threshold)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.text, err)
+			continue
+		}
+		if threshold != test.want {
+			t.Errorf("%q parsed as %+v, want %+v", test.text, threshold, test.want)
+		}
+	}
+}
+
+func TestThresholdReached(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+	tests := []struct {
+		name       string
+		threshold  Threshold
+		notBefore  time.Time
+		notAfter   time.Time
+		wantBefore time.Duration
+		want       bool
+	}{
+		{"duration not reached", Threshold{Duration: 30 * day}, now.Add(-50 * day), now.Add(40 * day), 30 * day, false},
+		{"duration reached", Threshold{Duration: 30 * day}, now.Add(-70 * day), now.Add(20 * day), 30 * day, true},
+		{"duration ignores lifetime", Threshold{Duration: 30 * day}, now.Add(-day), now.Add(10 * day), 30 * day, true},
+		{"percentage not reached", Threshold{Percent: 33}, now.Add(-50 * day), now.Add(50 * day), 33 * day, false},
+		{"percentage reached", Threshold{Percent: 33}, now.Add(-70 * day), now.Add(30 * day), 33 * day, true},
+		{"percentage of short lifetime", Threshold{Percent: 50}, now.Add(-5 * day), now.Add(2 * day), 3*day + 12*time.Hour, true},
+		{"percentage of long lifetime", Threshold{Percent: 10}, now.Add(-300 * day), now.Add(65 * day), 36*day + 12*time.Hour, false},
+		{"full lifetime", Threshold{Percent: 100}, now.Add(-time.Minute), now.Add(90 * day), 90*day + time.Minute, true},
+		{"expired", Threshold{Duration: time.Hour}, now.Add(-90 * day), now.Add(-time.Hour), time.Hour, true},
+	}
+	for _, test := range tests {
+		if before := test.threshold.Before(test.notBefore, test.notAfter); before != test.wantBefore {
+			t.Errorf("%s: Before returned %v, want %v", test.name, before, test.wantBefore)
+		}
+		if reached := test.threshold.Reached(test.notBefore, test.notAfter); reached != test.want {
+			t.Errorf("%s: Reached returned %v, want %v", test.name, reached, test.want)
+		}
+	}
+}
+
+func TestRenewBeforeFallback(t *testing.T) {
+	var config Config
+	domain := Domain{DomainName: "www.example.com"}
+	if got := config.RenewBefore(domain); got != DefaultRenewBefore {
+		t.Errorf("unconfigured renew_before is %v, want %v", got, DefaultRenewBefore)
+	}
+	if !config.WarnBefore(domain).IsZero() {
+		t.Error("unconfigured warn_before is not disabled")
+	}
+
+	config.Renewal.RenewBefore = Threshold{Percent: 33}
+	config.Renewal.WarnBefore = Threshold{Duration: 30 * 24 * time.Hour}
+	if got := config.RenewBefore(domain); got != config.Renewal.RenewBefore {
+		t.Errorf("renew_before is %v, want the [renewal] setting", got)
+	}
+
+	domain.RenewBefore = Threshold{Duration: 14 * 24 * time.Hour}
+	domain.WarnBefore = Threshold{Percent: 10}
+	if got := config.RenewBefore(domain); got != domain.RenewBefore {
+		t.Errorf("renew_before is %v, want the domain setting", got)
+	}
+	if got := config.WarnBefore(domain); got != domain.WarnBefore {
+		t.Errorf("warn_before is %v, want the domain setting", got)
+	}
+}